@@ -0,0 +1,275 @@
+package dev
+
+import (
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+	"github.com/udhos/jazigo/temp"
+)
+
+// handleConnectionHang never sends a prompt, holding the fetch until its timeouts expire.
+func handleConnectionHang(t *testing.T, c net.Conn, options optionsCiscoIOS) {
+	defer c.Close()
+	io.Copy(io.Discard, c)
+}
+
+func waitFetchRunning(t *testing.T, tab *DeviceTable, id string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !tab.FetchRunning(id) {
+		if time.Now().After(deadline) {
+			t.Fatalf("fetch for '%s' did not start", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAbortFetch(t *testing.T) {
+
+	// launch bogus test server
+	addr := ":2051"
+	ln, listenErr := net.Listen("tcp", addr)
+	if listenErr != nil {
+		t.Fatalf("could not spawn hanging server: %v", listenErr)
+	}
+	s := &testServer{listener: ln, done: make(chan int)}
+	go acceptLoop(t, s, handleConnectionHang, optionsCiscoIOS{})
+	defer func() {
+		s.close()
+		<-s.done
+	}()
+
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "cisco-ios", "lab1", "localhost"+addr, "telnet", "lab", "pass", "en", false, nil)
+	CreateDevice(tab, logger, "cisco-ios", "lab2", "localhost"+addr, "telnet", "lab", "pass", "en", false, nil)
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	appConfig := &conf.AppConfig{MaxConcurrency: 1, MaxConfigFiles: 10}
+	opt := conf.NewOptions()
+	opt.Set(appConfig)
+
+	requestCh := make(chan FetchRequest)
+	errlogPrefix := filepath.Join(repo, "errlog_test.")
+	done := make(chan struct{})
+	go func() {
+		Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+		close(done)
+	}()
+	defer func() {
+		close(requestCh) // shutdown Spawner
+		<-done           // do not log after test completion
+	}()
+
+	if tab.AbortFetch("lab1") {
+		t.Errorf("aborted fetch not running")
+	}
+
+	// abort single device
+	replyCh := make(chan FetchResult)
+	requestCh <- FetchRequest{ID: "lab1", ReplyChan: replyCh}
+	waitFetchRunning(t, tab, "lab1")
+	if !tab.AbortFetch("lab1") {
+		t.Errorf("could not abort running fetch")
+	}
+	select {
+	case r := <-replyCh:
+		if r.Code != fetchErrAbort || !strings.HasPrefix(r.Msg, "aborted: ") {
+			t.Errorf("abort fetch: code=%d msg=%s", r.Code, r.Msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("abort fetch: fetch did not stop")
+	}
+	if tab.FetchRunning("lab1") {
+		t.Errorf("aborted fetch still registered")
+	}
+
+	// abort scan: first device aborted, second device never launched
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d1, _ := tab.GetDevice("lab1")
+	d2, _ := tab.GetDevice("lab2")
+	scanDone := make(chan [3]int)
+	go func() {
+		success, failed, skipped := Scan(ctx, nil, tab, []*Device{d1, d2}, logger, appConfig, requestCh)
+		scanDone <- [3]int{success, failed, skipped}
+	}()
+	waitFetchRunning(t, tab, "lab1")
+	cancel()
+	select {
+	case got := <-scanDone:
+		if got != [3]int{0, 2, 1} {
+			t.Errorf("abort scan: success/failed/skipped=%v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("abort scan: scan did not stop")
+	}
+}
+
+func TestFetchJoin(t *testing.T) {
+
+	// launch bogus test server counting sessions
+	addr := ":2052"
+	ln, listenErr := net.Listen("tcp", addr)
+	if listenErr != nil {
+		t.Fatalf("could not spawn hanging server: %v", listenErr)
+	}
+	var sessions atomic.Int32
+	s := &testServer{listener: ln, done: make(chan int)}
+	go acceptLoop(t, s, func(t *testing.T, c net.Conn, options optionsCiscoIOS) {
+		sessions.Add(1)
+		handleConnectionHang(t, c, options)
+	}, optionsCiscoIOS{})
+	defer func() {
+		s.close()
+		<-s.done
+	}()
+
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "cisco-ios", "lab1", "localhost"+addr, "telnet", "lab", "pass", "en", false, nil)
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	opt := conf.NewOptions()
+	opt.Set(&conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10})
+
+	requestCh := make(chan FetchRequest)
+	errlogPrefix := filepath.Join(repo, "errlog_test.")
+	done := make(chan struct{})
+	go func() {
+		Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+		close(done)
+	}()
+	defer func() {
+		close(requestCh) // shutdown Spawner
+		<-done           // do not log after test completion
+	}()
+
+	// run now, then scan, then run now again: a single session
+	replyCh := make(chan FetchResult)
+	requestCh <- FetchRequest{ID: "lab1"}
+	waitFetchRunning(t, tab, "lab1")
+	requestCh <- FetchRequest{ID: "lab1", ReplyChan: replyCh}
+	requestCh <- FetchRequest{ID: "lab1"}
+
+	for deadline := time.Now().Add(5 * time.Second); sessions.Load() < 1 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond) // let the session reach the server
+	}
+	tab.AbortFetch("lab1")
+
+	select {
+	case r := <-replyCh:
+		if r.Code != fetchErrAbort {
+			t.Errorf("joined request: code=%d msg=%s", r.Code, r.Msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("joined request: no result")
+	}
+
+	if n := sessions.Load(); n != 1 {
+		t.Errorf("sessions: got=%d want=1", n)
+	}
+	if tab.FetchRunning("lab1") {
+		t.Errorf("fetch still registered after result")
+	}
+
+	// scan joins run now: aborting the scan releases its request only, the fetch goes on for run now
+	scanCtx, scanCancel := context.WithCancel(context.Background())
+	defer scanCancel()
+	requestCh <- FetchRequest{ID: "lab1"}
+	waitFetchRunning(t, tab, "lab1")
+	requestCh <- FetchRequest{ID: "lab1", ReplyChan: replyCh, Ctx: scanCtx}
+	waitHolders(t, tab, "lab1", 2)
+	scanCancel()
+	expectAbort(t, replyCh, "scan joined run now")
+	if !tab.FetchRunning("lab1") {
+		t.Errorf("scan abort cancelled run now fetch")
+	}
+	tab.AbortFetch("lab1")
+	waitFetchStopped(t, tab, "lab1")
+
+	// run now joins scan: aborting the scan releases its request only
+	scanCtx, scanCancel = context.WithCancel(context.Background())
+	defer scanCancel()
+	requestCh <- FetchRequest{ID: "lab1", ReplyChan: replyCh, Ctx: scanCtx}
+	waitFetchRunning(t, tab, "lab1")
+	runNowCh := make(chan FetchResult)
+	requestCh <- FetchRequest{ID: "lab1", ReplyChan: runNowCh}
+	waitHolders(t, tab, "lab1", 2)
+	scanCancel()
+	expectAbort(t, replyCh, "run now joined scan")
+	if !tab.FetchRunning("lab1") {
+		t.Errorf("scan abort cancelled fetch joined by run now")
+	}
+	tab.AbortFetch("lab1")
+	expectAbort(t, runNowCh, "run now after abort fetch")
+
+	// two scans: the fetch is cancelled when both are aborted
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	replyCh2 := make(chan FetchResult)
+	requestCh <- FetchRequest{ID: "lab1", ReplyChan: replyCh, Ctx: ctx1}
+	waitFetchRunning(t, tab, "lab1")
+	requestCh <- FetchRequest{ID: "lab1", ReplyChan: replyCh2, Ctx: ctx2}
+	waitHolders(t, tab, "lab1", 2)
+	cancel1()
+	expectAbort(t, replyCh, "first scan")
+	if !tab.FetchRunning("lab1") {
+		t.Errorf("first scan abort cancelled shared fetch")
+	}
+	cancel2()
+	expectAbort(t, replyCh2, "second scan")
+	waitFetchStopped(t, tab, "lab1")
+}
+
+func expectAbort(t *testing.T, replyCh chan FetchResult, label string) {
+	select {
+	case r := <-replyCh:
+		if r.Code != fetchErrAbort {
+			t.Errorf("%s: code=%d msg=%s", label, r.Code, r.Msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s: no result", label)
+	}
+}
+
+func waitHolders(t *testing.T, tab *DeviceTable, id string, holders int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tab.runningLock.Lock()
+		r, found := tab.running[id]
+		got := found && r.holders >= holders
+		tab.runningLock.Unlock()
+		if got {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("fetch for '%s' not joined", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitFetchStopped(t *testing.T, tab *DeviceTable, id string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for tab.FetchRunning(id) {
+		if time.Now().After(deadline) {
+			t.Fatalf("fetch for '%s' did not stop", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,124 @@
+package dev
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/udhos/jazigo/conf"
+	"github.com/udhos/jazigo/store"
+	"github.com/udhos/jazigo/temp"
+)
+
+func TestMaxCaptureSize(t *testing.T) {
+
+	// launch bogus test server
+	addr := ":2047"
+	s, listenErr := spawnServerCiscoIOS(t, addr, optionsCiscoIOS{sendUsername: true})
+	if listenErr != nil {
+		t.Fatalf("could not spawn bogus CiscoIOS server: %v", listenErr)
+	}
+	defer func() {
+		s.close()
+		<-s.done
+	}()
+
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "cisco-ios", "lab1", "localhost"+addr, "telnet", "lab", "pass", "en", false, nil)
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	appConfig := &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10}
+
+	if r := fetchOneRepo(t, tab, logger, "lab1", appConfig, repo); r.Code != fetchErrNone {
+		t.Fatalf("unlimited: code=%d msg=%s", r.Code, r.Msg)
+	}
+	full := string(lastConfig(t, repo, "lab1"))
+
+	d, _ := tab.GetDevice("lab1")
+	d.Attr.MaxCaptureSize = int64(len(full) / 2)
+	tab.UpdateDevice(d)
+
+	r := fetchOneRepo(t, tab, logger, "lab1", appConfig, repo)
+	if r.Code != fetchErrCapture || !strings.Contains(r.Msg, "capture size exceeded") {
+		t.Fatalf("limited: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	prefix := DeviceFullPrefix(repo, "lab1")
+	if list, _ := store.ListTmp(prefix); len(list) > 0 {
+		t.Errorf("limited: tmp file left behind")
+	}
+	if last, _ := store.FindLastConfig(prefix, logger); filepath.Base(last) != "lab1.0" {
+		t.Errorf("limited: unexpected config saved: %s", last)
+	}
+
+	d.Attr.MaxCaptureSize = int64(len(full) * 2)
+	tab.UpdateDevice(d)
+
+	if r := fetchOneRepo(t, tab, logger, "lab1", appConfig, repo); r.Code != fetchErrNone {
+		t.Fatalf("raised limit: code=%d msg=%s", r.Code, r.Msg)
+	}
+	if got := string(lastConfig(t, repo, "lab1")); got != full {
+		t.Errorf("raised limit: streamed config differs:\n%q\n%q", got, full)
+	}
+}
+
+func TestSaveLinesStream(t *testing.T) {
+
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "cisco-ios", "lab1", "localhost:2001", "telnet", "lab", "pass", "en", false, nil)
+	d, _ := tab.GetDevice("lab1")
+	d.Attr.EndMarkers = []conf.EndMarker{{Command: "show run", Pattern: `^end$`}}
+	d.Attr.ErrorPatterns = []string{`^% Invalid`}
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	var capture dialog
+	if err := d.saveOpen(logger, &capture, repo, NewFilterTable(logger)); err != nil {
+		t.Fatalf("saveOpen: %v", err)
+	}
+	errPatterns, _ := d.errorPatterns()
+	if err := d.saveBegin(&capture, "show run", errPatterns); err != nil {
+		t.Fatalf("saveBegin: %v", err)
+	}
+
+	var buf []byte
+	for _, chunk := range []string{"hostname lab", "1\r\n% Invalid input\r\n!\r\ne", "nd\r\nlab1#"} {
+		buf = append(buf, chunk...)
+		var err error
+		if buf, err = d.saveLines(&capture, buf); err != nil {
+			t.Fatalf("saveLines: %v", err)
+		}
+		if i := strings.IndexByte(string(buf), LF); i >= 0 {
+			t.Errorf("saveLines kept complete line: %q", buf)
+		}
+	}
+	if string(buf) != "lab1#" {
+		t.Errorf("unsaved tail: %q", buf)
+	}
+	if line, found := capture.cmd.findError(buf); !found || string(line) != "% Invalid input" {
+		t.Errorf("streamed error line: found=%v line=%q", found, line)
+	}
+	if err := d.saveEnd(&capture, buf); err != nil {
+		t.Fatalf("saveEnd: %v", err)
+	}
+	if len(capture.truncated) > 0 {
+		t.Errorf("streamed end marker missed: %v", capture.truncated)
+	}
+	if err := d.saveCommit(logger, &capture, 10, nil); err != nil {
+		t.Fatalf("saveCommit: %v", err)
+	}
+
+	want := "\n!![\"show run\"]\nhostname lab1\r\n% Invalid input\r\n!\r\nend\r\nlab1#"
+	got, _ := os.ReadFile(filepath.Join(repo, "lab1", "lab1.0"))
+	if string(got) != want {
+		t.Errorf("streamed config:\n got=%q\nwant=%q", got, want)
+	}
+}
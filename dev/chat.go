@@ -0,0 +1,97 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+// chatSteps picks the chat script for a command: the per-command override, or the default ChatScript.
+func (d *Device) chatSteps(command string) []conf.ChatStep {
+	for _, c := range d.Attr.CommandChats {
+		if c.Command == command {
+			return c.Steps
+		}
+	}
+	return d.Attr.ChatScript
+}
+
+// matchCommandChat waits for the command prompt, answering the chat script steps found on the way.
+// It returns the command output not yet saved into the tmp file, including the incomplete question lines.
+func (d *Device) matchCommandChat(ctx context.Context, logger hasPrintf, t transp, capture *dialog, command string) ([]byte, bool, error) {
+
+	steps := d.chatSteps(command)
+	if len(steps) == 0 {
+		matchBuf, _, wantEOF, err := d.matchCommandPrompt(ctx, t, capture)
+		return matchBuf, wantEOF, err
+	}
+
+	wantEOF := d.Attr.DisabledPromptPattern == ""
+
+	var output []byte
+
+	for i := 0; i < len(steps); {
+		step := steps[i]
+
+		// step pattern first, then prompts
+		// empty prompt pattern means look for EOF, then only the step pattern is matched
+		list := []string{step.Expect}
+		if !wantEOF {
+			list = append(list, d.Attr.DisabledPromptPattern)
+			if d.Attr.EnabledPromptPattern != "" {
+				list = append(list, d.Attr.EnabledPromptPattern)
+			}
+		}
+
+		m, matchBuf, err := d.matchStep(ctx, logger, t, capture, list, step)
+		output = matchBuf // includes output left unsaved by previous step
+
+		switch err {
+		case nil: // ok
+		case io.EOF:
+			return output, wantEOF, err // return original EOF error
+		default:
+			return output, wantEOF, fmt.Errorf("matchCommandChat: step %d/%d: %v", i, len(steps), err)
+		}
+
+		if m != 0 {
+			d.debugf("matchCommandChat: found command prompt at step %d/%d", i, len(steps))
+			return output, wantEOF, nil
+		}
+
+		d.debugf("matchCommandChat: step %d/%d matched [%s] sending [%q]", i, len(steps), step.Expect, step.Send)
+
+		if sendErr := d.send(logger, t, step.Send); sendErr != nil {
+			return output, wantEOF, fmt.Errorf("matchCommandChat: step %d/%d: send: %v", i, len(steps), sendErr)
+		}
+
+		capture.cmd.tail = output // resumed by next match
+
+		if !step.Repeat {
+			i++
+		}
+	}
+
+	// all steps answered
+	matchBuf, _, _, err := d.matchCommandPrompt(ctx, t, capture)
+	return matchBuf, wantEOF, err
+}
+
+// matchStep runs match under the step timeout, which also caps the per-read timeout.
+func (d *Device) matchStep(ctx context.Context, logger hasPrintf, t transp, capture *dialog, list []string, step conf.ChatStep) (int, []byte, error) {
+	if step.Timeout > 0 {
+		saveReadTimeout := d.Attr.ReadTimeout
+		saveMatchTimeout := d.Attr.MatchTimeout
+		d.Attr.MatchTimeout = step.Timeout
+		if d.Attr.ReadTimeout > step.Timeout {
+			d.Attr.ReadTimeout = step.Timeout
+		}
+		defer func() {
+			d.Attr.ReadTimeout = saveReadTimeout
+			d.Attr.MatchTimeout = saveMatchTimeout
+		}()
+	}
+	return d.match(ctx, logger, t, capture, list)
+}
@@ -0,0 +1,73 @@
+package dev
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+	"github.com/udhos/jazigo/temp"
+)
+
+func TestChatScript(t *testing.T) {
+
+	// launch bogus test server
+	addr := ":2045"
+	s, listenErr := spawnServerCiscoIOS(t, addr, optionsCiscoIOS{sendUsername: true})
+	if listenErr != nil {
+		t.Fatalf("could not spawn bogus CiscoIOS server: %v", listenErr)
+	}
+	defer func() {
+		s.close()
+		<-s.done
+	}()
+
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "cisco-ios", "lab1", "localhost"+addr, "telnet", "lab", "pass", "en", false, nil)
+	d, _ := tab.GetDevice("lab1")
+	d.Attr.CommandList = []string{"copy run start", "more flash:big", "show run"}
+	d.Attr.ChatScript = []conf.ChatStep{
+		{Expect: `--More--`, Send: " ", Repeat: true},
+	}
+	d.Attr.CommandChats = []conf.CommandChat{
+		{Command: "copy run start", Steps: []conf.ChatStep{
+			{Expect: `Destination filename \[\S+\]\?`, Send: "\n", Timeout: 5 * time.Second},
+			{Expect: `continue \(y/n\)\?`, Send: "y\n"},
+		}},
+	}
+	tab.UpdateDevice(d)
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	r := fetchOneRepo(t, tab, logger, "lab1", &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10}, repo)
+	if r.Code != fetchErrNone {
+		t.Fatalf("chat: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	got := string(lastConfig(t, repo, "lab1"))
+	for _, want := range []string{`answer="\n"`, `answer="y\n"`, "page3", "last page", "show running-configuration"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("chat: missing %q in:\n%s", want, got)
+		}
+	}
+
+	// unanswered question times out at step timeout
+
+	d.Attr.CommandList = []string{"copy run start"}
+	d.Attr.CommandChats[0].Steps = []conf.ChatStep{
+		{Expect: `no such question`, Send: "\n", Timeout: time.Second},
+	}
+	tab.UpdateDevice(d)
+
+	begin := time.Now()
+	r = fetchOneRepo(t, tab, logger, "lab1", &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10}, repo)
+	if r.Code != fetchErrCommands {
+		t.Errorf("chat timeout: code=%d msg=%s", r.Code, r.Msg)
+	}
+	if elap := time.Since(begin); elap > 15*time.Second {
+		t.Errorf("chat timeout: step timeout ignored: elapsed=%v", elap)
+	}
+}
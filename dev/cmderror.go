@@ -0,0 +1,69 @@
+package dev
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+// commandError reports a command output line matching Attr.ErrorPatterns.
+type commandError struct {
+	command string
+	line    string
+}
+
+func (e *commandError) Error() string {
+	return fmt.Sprintf("command '%s' reported error: [%s]", e.command, e.line)
+}
+
+// errorPatterns holds compiled Attr.ErrorPatterns and Attr.ErrorAllowPatterns.
+type errorPatterns struct {
+	errors []*regexp.Regexp
+	allow  []*regexp.Regexp
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var list []*regexp.Regexp
+	for _, p := range patterns {
+		exp, badExp := regexp.Compile(p)
+		if badExp != nil {
+			return nil, fmt.Errorf("bad pattern '%s': %v", p, badExp)
+		}
+		list = append(list, exp)
+	}
+	return list, nil
+}
+
+func (d *Device) errorPatterns() (*errorPatterns, error) {
+	errList, errorsErr := compilePatterns(d.Attr.ErrorPatterns)
+	if errorsErr != nil {
+		return nil, fmt.Errorf("error patterns: %v", errorsErr)
+	}
+	allowList, allowErr := compilePatterns(d.Attr.ErrorAllowPatterns)
+	if allowErr != nil {
+		return nil, fmt.Errorf("error allow patterns: %v", allowErr)
+	}
+	return &errorPatterns{errors: errList, allow: allowList}, nil
+}
+
+// find returns the first output line matching an error pattern, skipping lines matching an allow pattern.
+func (p *errorPatterns) find(output []byte) ([]byte, bool) {
+	if len(p.errors) == 0 {
+		return nil, false
+	}
+LINE_LOOP:
+	for _, line := range bytes.Split(output, []byte{LF}) {
+		line = bytes.TrimRight(line, "\r")
+		for _, exp := range p.allow {
+			if exp.Match(line) {
+				continue LINE_LOOP
+			}
+		}
+		for _, exp := range p.errors {
+			if exp.Match(line) {
+				return line, true
+			}
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,57 @@
+package dev
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/udhos/jazigo/conf"
+	"github.com/udhos/jazigo/temp"
+)
+
+func TestErrorPatterns(t *testing.T) {
+
+	// launch bogus test server
+	addr := ":2048"
+	s, listenErr := spawnServerCiscoIOS(t, addr, optionsCiscoIOS{sendUsername: true})
+	if listenErr != nil {
+		t.Fatalf("could not spawn bogus CiscoIOS server: %v", listenErr)
+	}
+	defer func() {
+		s.close()
+		<-s.done
+	}()
+
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "cisco-ios", "lab1", "localhost"+addr, "telnet", "lab", "pass", "en", false, nil)
+	d, _ := tab.GetDevice("lab1")
+	d.Attr.CommandList = []string{"show run", "bogus command"}
+	tab.UpdateDevice(d)
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	appConfig := &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10}
+
+	r := fetchOneRepo(t, tab, logger, "lab1", appConfig, repo)
+	if r.Code != fetchErrOutput {
+		t.Fatalf("error pattern: code=%d msg=%s", r.Code, r.Msg)
+	}
+	for _, want := range []string{"'bogus command'", "% Invalid input detected at '^' marker."} {
+		if !strings.Contains(r.Msg, want) {
+			t.Errorf("error pattern: message missing %q: %s", want, r.Msg)
+		}
+	}
+
+	// expected error
+	d.Attr.ErrorAllowPatterns = []string{`Invalid input`}
+	tab.UpdateDevice(d)
+
+	if r := fetchOneRepo(t, tab, logger, "lab1", appConfig, repo); r.Code != fetchErrNone {
+		t.Fatalf("allowed error: code=%d msg=%s", r.Code, r.Msg)
+	}
+	if got := string(lastConfig(t, repo, "lab1")); !strings.Contains(got, "Invalid input detected") {
+		t.Errorf("allowed error: output not saved:\n%q", got)
+	}
+}
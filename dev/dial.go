@@ -0,0 +1,68 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+const dialTimeoutDefault = 10 * time.Second
+
+// netDialer opens plain TCP connections honoring the local dial options.
+type netDialer struct {
+	timeout time.Duration
+	network string          // tcp, tcp4 or tcp6
+	source  net.Addr        // local bind address, nil lets the OS choose
+	ctx     context.Context // cancels dialing, nil means never cancelled
+}
+
+func (nd *netDialer) dial(address string) (net.Conn, error) {
+	d := net.Dialer{Timeout: nd.timeout, LocalAddr: nd.source}
+	if nd.ctx != nil {
+		return d.DialContext(nd.ctx, nd.network, address)
+	}
+	return d.Dial(nd.network, address)
+}
+
+// newNetDialer merges device dial options over global defaults.
+func newNetDialer(c *conf.DevConfig, opt *conf.AppConfig) (netDialer, error) {
+	nd := netDialer{timeout: dialTimeoutDefault, network: "tcp"}
+
+	switch {
+	case c.DialTimeout > 0:
+		nd.timeout = c.DialTimeout
+	case opt.DialTimeout > 0:
+		nd.timeout = opt.DialTimeout
+	}
+
+	family := opt.AddressFamily
+	if c.AddressFamily != "" {
+		family = c.AddressFamily
+	}
+	switch family {
+	case "", "any":
+	case "ipv4":
+		nd.network = "tcp4"
+	case "ipv6":
+		nd.network = "tcp6"
+	default:
+		return nd, fmt.Errorf("newNetDialer: bad address family: '%s'", family)
+	}
+
+	source := opt.SourceAddress
+	if c.SourceAddress != "" {
+		source = c.SourceAddress
+	}
+	if source != "" {
+		ip := net.ParseIP(source)
+		if ip == nil {
+			return nd, fmt.Errorf("newNetDialer: bad source address: '%s'", source)
+		}
+		nd.source = &net.TCPAddr{IP: ip}
+	}
+
+	return nd, nil
+}
@@ -0,0 +1,83 @@
+package dev
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+func TestNetDialerOptions(t *testing.T) {
+
+	global := &conf.AppConfig{DialTimeout: 30 * time.Second, SourceAddress: "127.0.0.1", AddressFamily: "ipv6"}
+
+	// device inherits global defaults
+	nd, err := newNetDialer(&conf.DevConfig{}, global)
+	if err != nil || nd.timeout != 30*time.Second || nd.network != "tcp6" || nd.source.String() != "127.0.0.1:0" {
+		t.Errorf("global: timeout=%v network=%s source=%v error=%v", nd.timeout, nd.network, nd.source, err)
+	}
+
+	// device overrides global defaults
+	nd, err = newNetDialer(&conf.DevConfig{DialTimeout: time.Minute, SourceAddress: "127.0.0.2", AddressFamily: "ipv4"}, global)
+	if err != nil || nd.timeout != time.Minute || nd.network != "tcp4" || nd.source.String() != "127.0.0.2:0" {
+		t.Errorf("device: timeout=%v network=%s source=%v error=%v", nd.timeout, nd.network, nd.source, err)
+	}
+
+	// built-in defaults
+	nd, err = newNetDialer(&conf.DevConfig{}, &conf.AppConfig{})
+	if err != nil || nd.timeout != dialTimeoutDefault || nd.network != "tcp" || nd.source != nil {
+		t.Errorf("default: timeout=%v network=%s source=%v error=%v", nd.timeout, nd.network, nd.source, err)
+	}
+
+	if _, err = newNetDialer(&conf.DevConfig{AddressFamily: "ipv5"}, global); err == nil {
+		t.Errorf("bad family: unexpected success")
+	}
+	if _, err = newNetDialer(&conf.DevConfig{SourceAddress: "loopback0"}, global); err == nil {
+		t.Errorf("bad source: unexpected success")
+	}
+}
+
+func TestNetDialerSource(t *testing.T) {
+
+	ln, listenErr := net.Listen("tcp4", "127.0.0.1:2043")
+	if listenErr != nil {
+		t.Fatalf("listen: %v", listenErr)
+	}
+	defer ln.Close()
+
+	peer := make(chan string, 1)
+	go func() {
+		conn, acceptErr := ln.Accept()
+		if acceptErr != nil {
+			peer <- acceptErr.Error()
+			return
+		}
+		host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		conn.Close()
+		peer <- host
+	}()
+
+	// whole 127.0.0.0/8 is local on loopback
+	nd, _ := newNetDialer(&conf.DevConfig{SourceAddress: "127.0.0.2", AddressFamily: "ipv4"}, &conf.AppConfig{})
+	dl := &dialer{netDialer: nd, proxy: directDialer{}}
+
+	conn, dialErr := dl.dial("localhost:2043")
+	if dialErr != nil {
+		t.Fatalf("dial: %v", dialErr)
+	}
+	conn.Close()
+
+	if got := <-peer; got != "127.0.0.2" {
+		t.Errorf("source address: got=%s want=127.0.0.2", got)
+	}
+
+	// ipv6 only cannot reach ipv4 listener
+	nd, _ = newNetDialer(&conf.DevConfig{AddressFamily: "ipv6"}, &conf.AppConfig{DialTimeout: time.Second})
+	dl = &dialer{netDialer: nd, proxy: directDialer{}}
+
+	if conn, dialErr = dl.dial("127.0.0.1:2043"); dialErr == nil {
+		conn.Close()
+		t.Errorf("ipv6 family: unexpected connection to ipv4 address")
+	}
+}
@@ -0,0 +1,48 @@
+package dev
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// truncatedError reports command outputs missing their end marker.
+type truncatedError struct {
+	commands []string
+}
+
+func (e *truncatedError) Error() string {
+	return fmt.Sprintf("truncated output: missing end marker for command(s): '%s'", strings.Join(e.commands, "', '"))
+}
+
+// endMarker gets the end marker pattern for a command, empty if none.
+func (d *Device) endMarker(command string) string {
+	for _, m := range d.Attr.EndMarkers {
+		if m.Command == command {
+			return m.Pattern
+		}
+	}
+	return ""
+}
+
+// endMarkerExp compiles the end marker pattern for a command, nil if none.
+func (d *Device) endMarkerExp(command string) (*regexp.Regexp, error) {
+	pattern := d.endMarker(command)
+	if pattern == "" {
+		return nil, nil
+	}
+	exp, badExp := regexp.Compile(pattern)
+	if badExp != nil {
+		return nil, fmt.Errorf("endMarkerExp: bad pattern '%s': %v", pattern, badExp)
+	}
+	return exp, nil
+}
+
+// checkEndMarker records the command as truncated when its output lacked the end marker.
+func (d *Device) checkEndMarker(capture *dialog, o *commandOutput) {
+	if o.endExp == nil || o.endFound {
+		return
+	}
+	d.debugf("checkEndMarker: command=[%s] missing end marker [%s]", o.command, o.endExp)
+	capture.truncated = append(capture.truncated, o.command)
+}
@@ -0,0 +1,86 @@
+package dev
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/udhos/jazigo/conf"
+	"github.com/udhos/jazigo/store"
+	"github.com/udhos/jazigo/temp"
+)
+
+func TestEndMarker(t *testing.T) {
+
+	// launch bogus test server
+	addr := ":2049"
+	s, listenErr := spawnServerCiscoIOS(t, addr, optionsCiscoIOS{sendUsername: true})
+	if listenErr != nil {
+		t.Fatalf("could not spawn bogus CiscoIOS server: %v", listenErr)
+	}
+	defer func() {
+		s.close()
+		<-s.done
+	}()
+
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "cisco-ios", "lab1", "localhost"+addr, "telnet", "lab", "pass", "en", false, nil)
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	appConfig := &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10}
+
+	// default marker for cisco-ios: end
+	if r := fetchOneRepo(t, tab, logger, "lab1", appConfig, repo); r.Code != fetchErrNone {
+		t.Fatalf("complete: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	d, _ := tab.GetDevice("lab1")
+	d.Attr.EndMarkers = []conf.EndMarker{{Command: "show run", Pattern: `^missing marker$`}}
+	tab.UpdateDevice(d)
+
+	r := fetchOneRepo(t, tab, logger, "lab1", appConfig, repo)
+	if r.Code != fetchErrTrunc || !strings.Contains(r.Msg, "'show run'") {
+		t.Fatalf("truncated: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	prefix := DeviceFullPrefix(repo, "lab1")
+	if list, _ := store.ListTmp(prefix); len(list) > 0 {
+		t.Errorf("truncated: tmp file left behind")
+	}
+	if last, _ := store.FindLastConfig(prefix, logger); filepath.Base(last) != "lab1.0" {
+		t.Errorf("truncated: previous config not kept: %s", last)
+	}
+
+	b, readErr := os.ReadFile(ErrlogPath(filepath.Join(repo, "errlog_test."), "lab1"))
+	if readErr != nil {
+		t.Fatalf("errlog: %v", readErr)
+	}
+	if !strings.Contains(string(b), "missing end marker") {
+		t.Errorf("errlog: missing truncation report:\n%s", b)
+	}
+}
+
+func TestEndMarkerCommands(t *testing.T) {
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+
+	// a marker keyed to a command never sent would never apply
+	for _, name := range tab.ListModels() {
+		m, _ := tab.GetModel(name)
+	MARKER_LOOP:
+		for _, marker := range m.defaultAttr.EndMarkers {
+			for _, c := range m.defaultAttr.CommandList {
+				if c == marker.Command {
+					continue MARKER_LOOP
+				}
+			}
+			t.Errorf("model %s: end marker for command not sent: '%s'", name, marker.Command)
+		}
+	}
+}
@@ -54,11 +54,11 @@ func errlog(logger hasPrintf, result FetchResult, pathPrefix string, debug bool,
 
 	// push result
 	w := bufio.NewWriter(f)
-	msg := fmt.Sprintf("%s success=%v elapsed=%v model=%s dev=%s host=%s transport=%s code=%d message=[%s]",
+	msg := fmt.Sprintf("%s success=%v elapsed=%v model=%s dev=%s host=%s transport=%s auth=%s code=%d message=[%s]",
 		now.String(),
 		result.Code == fetchErrNone,
 		result.End.Sub(result.Begin),
-		result.Model, result.DevID, result.DevHostPort, result.Transport, result.Code, result.Msg)
+		result.Model, result.DevID, result.DevHostPort, result.Transport, result.Auth, result.Code, result.Msg)
 
 	logger.Printf("errlog: push: %s: %s", path, msg)
 
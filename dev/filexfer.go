@@ -0,0 +1,299 @@
+package dev
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/udhos/jazigo/conf"
+	"github.com/udhos/jazigo/store"
+)
+
+// remoteFile holds a downloaded file.
+type remoteFile struct {
+	path    string
+	content []byte
+}
+
+// errCaptureSize reports downloaded files exceeding Attr.MaxCaptureSize.
+var errCaptureSize = errors.New("capture size exceeded")
+
+// fileGetter downloads a remote file thru an authenticated ssh connection.
+type fileGetter func(client *ssh.Client, path string, maxSize int64) ([]byte, error)
+
+var fileTransports = map[string]fileGetter{
+	"sftp": sftpGet,
+	"scp":  scpGet,
+}
+
+// fetchFiles retrieves Attr.RemoteFiles instead of scraping command outputs.
+func (d *Device) fetchFiles(ctx context.Context, logger hasPrintf, repository string, opt *conf.AppConfig, begin time.Time) FetchResult {
+	modelName := d.devModel.name
+
+	dl, auth, hostKeyCheck, optErr := d.connectOptions(ctx, logger, repository, opt)
+	if optErr != nil {
+		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: d.Transports, Msg: fmt.Sprintf("fetch files: %v", optErr), Code: fetchErrTransp, Begin: begin}
+	}
+
+	hostPort := forceHostPort(d.HostPort, "22")
+
+	var lastErr error
+	lastCode := fetchErrTransp
+
+	for _, t := range strings.Split(d.Transports, ",") {
+		get, found := fileTransports[t]
+		if !found {
+			logger.Printf("fetchFiles: %s %s %s - not a file transport: '%s'", modelName, d.ID, d.HostPort, t)
+			continue
+		}
+
+		s, clientErr := openSSHClient(logger, modelName, d.ID, hostPort, dl, auth,
+			d.DevConfig.SSHClearCiphers, d.DevConfig.SSHAddCiphers, hostKeyCheck)
+		if clientErr != nil {
+			logger.Printf("fetchFiles: %v", clientErr)
+			lastErr = clientErr
+			lastCode = fetchTransportCode(clientErr)
+			continue
+		}
+
+		stop := context.AfterFunc(ctx, func() { s.conn.Close() }) // abort blocked transfers
+
+		var timer *time.Timer
+		if d.Attr.CommandMatchTimeout > 0 {
+			timer = time.AfterFunc(d.Attr.CommandMatchTimeout, func() { s.conn.Close() }) // abort stalled transfers
+		}
+
+		files, getErr := downloadFiles(s.client, get, d.Attr.RemoteFiles, d.Attr.MaxCaptureSize)
+
+		if timer != nil && !timer.Stop() && getErr != nil {
+			getErr = fmt.Errorf("transfer timed out: %s: %v", d.Attr.CommandMatchTimeout, getErr)
+		}
+		stop()
+		s.client.Close()
+		s.conn.Close()
+
+		if getErr != nil {
+			logger.Printf("fetchFiles: %s - %s: %v", s.devLabel, t, getErr)
+			lastErr = getErr
+			lastCode = fetchErrCommands
+			if errors.Is(getErr, errCaptureSize) {
+				lastCode = fetchErrCapture
+			}
+			continue
+		}
+
+		if saveErr := d.saveFiles(logger, files, repository, opt.MaxConfigFiles, d.shrinkGuard(opt)); saveErr != nil {
+			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: t, Auth: s.auth, Msg: fmt.Sprintf("save files: %v", saveErr), Code: saveErrCode(saveErr), Begin: begin}
+		}
+
+		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: t, Auth: s.auth, Code: fetchErrNone, Begin: begin}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no file transport (sftp,scp) in: '%s'", d.Transports)
+	}
+
+	return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: d.Transports, Msg: fmt.Sprintf("fetch files: %v", lastErr), Code: lastCode, Begin: begin}
+}
+
+// downloadFiles gets the remote files, failing if their total size exceeds maxSize (when positive).
+func downloadFiles(client *ssh.Client, get fileGetter, paths []string, maxSize int64) ([]remoteFile, error) {
+	files := make([]remoteFile, 0, len(paths))
+	var total int64
+	for _, p := range paths {
+		limit := maxSize
+		if maxSize > 0 {
+			if limit = maxSize - total; limit < 1 {
+				return nil, fmt.Errorf("%s: %w: max=%d", p, errCaptureSize, maxSize)
+			}
+		}
+		content, err := get(client, p, limit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		total += int64(len(content))
+		files = append(files, remoteFile{path: p, content: content})
+	}
+	return files, nil
+}
+
+// saveFiles stores a single file as is, or multiple files as a tar archive.
+// Content is saved verbatim: no line filter, no control char removal.
+func (d *Device) saveFiles(logger hasPrintf, files []remoteFile, repository string, maxFiles int, guard *store.ShrinkGuard) error {
+
+	devDir := d.DeviceDir(repository)
+
+	if mkdirErr := store.MkDir(devDir); mkdirErr != nil {
+		return fmt.Errorf("saveFiles: mkdir: error: %v", mkdirErr)
+	}
+
+	writeFunc := func(w store.HasWrite) error {
+		if len(files) == 1 {
+			_, err := w.Write(files[0].content)
+			return err
+		}
+		return writeTar(w, files)
+	}
+
+	path, writeErr := store.SaveNewConfig(d.DevicePathPrefix(devDir), maxFiles, logger, writeFunc, d.Attr.ChangesOnly, d.Attr.S3ContentType, guard)
+	if writeErr != nil {
+		return fmt.Errorf("saveFiles: error: %w", writeErr)
+	}
+
+	logger.Printf("saveFiles: dev '%s' saved %d file(s) to '%s'", d.ID, len(files), path)
+
+	return nil
+}
+
+// writeTar archives files with fixed metadata, so unchanged files produce identical archives.
+func writeTar(w store.HasWrite, files []remoteFile) error {
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:     strings.TrimPrefix(f.path, "/"),
+			Mode:     0644,
+			Size:     int64(len(f.content)),
+			Typeflag: tar.TypeReg,
+			Format:   tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.content); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readLimited reads r fully, failing if it exceeds maxSize (when positive).
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		return io.ReadAll(r)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > maxSize {
+		return nil, fmt.Errorf("%w: max=%d", errCaptureSize, maxSize)
+	}
+	return b, nil
+}
+
+func sftpGet(client *ssh.Client, path string, maxSize int64) ([]byte, error) {
+	c, clientErr := sftp.NewClient(client)
+	if clientErr != nil {
+		return nil, fmt.Errorf("sftpGet: %v", clientErr)
+	}
+	defer c.Close()
+
+	f, openErr := c.Open(path)
+	if openErr != nil {
+		return nil, fmt.Errorf("sftpGet: %v", openErr)
+	}
+	defer f.Close()
+
+	b, readErr := readLimited(f, maxSize)
+	if readErr != nil {
+		return nil, fmt.Errorf("sftpGet: %w", readErr)
+	}
+
+	return b, nil
+}
+
+// scpGet runs the remote 'scp -f' (source mode) and acts as the sink side of the protocol.
+func scpGet(client *ssh.Client, path string, maxSize int64) ([]byte, error) {
+	ses, sessionErr := client.NewSession()
+	if sessionErr != nil {
+		return nil, fmt.Errorf("scpGet: NewSession: %v", sessionErr)
+	}
+	defer ses.Close()
+
+	stdout, outErr := ses.StdoutPipe()
+	if outErr != nil {
+		return nil, fmt.Errorf("scpGet: StdoutPipe: %v", outErr)
+	}
+	stdin, inErr := ses.StdinPipe()
+	if inErr != nil {
+		return nil, fmt.Errorf("scpGet: StdinPipe: %v", inErr)
+	}
+
+	if startErr := ses.Start("scp -f " + shellQuote(path)); startErr != nil {
+		return nil, fmt.Errorf("scpGet: start: %v", startErr)
+	}
+
+	r := bufio.NewReader(stdout)
+	ack := []byte{0}
+
+	if _, err := stdin.Write(ack); err != nil {
+		return nil, fmt.Errorf("scpGet: %v", err)
+	}
+
+	header, headerErr := r.ReadString('\n')
+	if headerErr != nil {
+		return nil, fmt.Errorf("scpGet: read header: %v", headerErr)
+	}
+
+	// C0644 <size> <name>
+	switch {
+	case len(header) > 0 && (header[0] == 1 || header[0] == 2):
+		return nil, fmt.Errorf("scpGet: remote error: %s", strings.TrimSpace(header[1:]))
+	case !strings.HasPrefix(header, "C"):
+		return nil, fmt.Errorf("scpGet: unexpected header: %q", header)
+	}
+	fields := strings.SplitN(strings.TrimSpace(header), " ", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("scpGet: bad header: %q", header)
+	}
+	size, sizeErr := strconv.ParseInt(fields[1], 10, 64)
+	if sizeErr != nil || size < 0 {
+		return nil, fmt.Errorf("scpGet: bad size in header: %q", header)
+	}
+	if maxSize > 0 && size > maxSize {
+		return nil, fmt.Errorf("scpGet: file size %d: %w: max=%d", size, errCaptureSize, maxSize)
+	}
+
+	if _, err := stdin.Write(ack); err != nil {
+		return nil, fmt.Errorf("scpGet: %v", err)
+	}
+
+	var buf bytes.Buffer // grown as content arrives, never sized from the untrusted header
+	if _, err := io.CopyN(&buf, r, size); err != nil {
+		return nil, fmt.Errorf("scpGet: read content: %v", err)
+	}
+
+	status, statusErr := r.ReadByte()
+	if statusErr != nil {
+		return nil, fmt.Errorf("scpGet: read status: %v", statusErr)
+	}
+	if status != 0 {
+		msg, _ := r.ReadString('\n')
+		return nil, fmt.Errorf("scpGet: remote error: %s", strings.TrimSpace(msg))
+	}
+
+	if _, err := stdin.Write(ack); err != nil {
+		return nil, fmt.Errorf("scpGet: %v", err)
+	}
+	stdin.Close()
+
+	if waitErr := ses.Wait(); waitErr != nil {
+		return nil, fmt.Errorf("scpGet: remote scp: %v", waitErr)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,152 @@
+package dev
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+	"github.com/udhos/jazigo/store"
+	"github.com/udhos/jazigo/temp"
+)
+
+// lastConfig reads the last config saved for device id.
+func lastConfig(t *testing.T, repo, id string) []byte {
+	logger := &testLogger{t}
+	last, findErr := store.FindLastConfig(DeviceFullPrefix(repo, id), logger)
+	if findErr != nil {
+		t.Fatalf("lastConfig: %v", findErr)
+	}
+	b, readErr := store.FileRead(last, 1000000)
+	if readErr != nil {
+		t.Fatalf("lastConfig: %v", readErr)
+	}
+	return b
+}
+
+func TestFileTransfer(t *testing.T) {
+
+	// launch bogus test server
+	addr := ":2039"
+	s := spawnJumpHost(t, addr, "lab", "pass") // password-only ssh server with sftp and scp
+
+	dir := t.TempDir()
+	text := []byte("# /etc/hosts\n127.0.0.1 localhost\n")
+	binary := make([]byte, 512)
+	for i := range binary {
+		binary[i] = byte(i) // includes NUL, CR, backspace and other control chars
+	}
+	textPath := filepath.Join(dir, "hosts")
+	binaryPath := filepath.Join(dir, "router.backup")
+	if err := os.WriteFile(textPath, text, 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(binaryPath, binary, 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// run client test
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	appConfig := &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10}
+
+	for _, transport := range []string{"sftp", "scp"} {
+		id := "lab-" + transport
+		CreateDevice(tab, logger, "sftp", id, "localhost"+addr, transport, "lab", "pass", "", false, nil)
+
+		// single binary file is saved verbatim
+
+		d, _ := tab.GetDevice(id)
+		d.Attr.RemoteFiles = []string{binaryPath}
+		d.Attr.ChangesOnly = false
+		tab.UpdateDevice(d)
+
+		repo := temp.MakeTempRepo()
+
+		r := fetchOneRepo(t, tab, logger, id, appConfig, repo)
+		if r.Code != fetchErrNone || r.Transport != transport || r.Auth != authPassword {
+			t.Errorf("%s single: code=%d transport=%s auth=%s msg=%s", transport, r.Code, r.Transport, r.Auth, r.Msg)
+		} else if got := lastConfig(t, repo, id); !bytes.Equal(got, binary) {
+			t.Errorf("%s single: binary content mismatch: size=%d", transport, len(got))
+		}
+
+		// multiple files are saved as tar archive
+
+		d.Attr.RemoteFiles = []string{textPath, binaryPath}
+		tab.UpdateDevice(d)
+
+		r = fetchOneRepo(t, tab, logger, id, appConfig, repo)
+		if r.Code != fetchErrNone {
+			t.Errorf("%s multiple: code=%d msg=%s", transport, r.Code, r.Msg)
+		} else {
+			tr := tar.NewReader(bytes.NewReader(lastConfig(t, repo, id)))
+			for _, want := range [][]byte{text, binary} {
+				if _, err := tr.Next(); err != nil {
+					t.Errorf("%s multiple: tar: %v", transport, err)
+					break
+				}
+				got, _ := io.ReadAll(tr)
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s multiple: tar content mismatch: size=%d", transport, len(got))
+				}
+			}
+		}
+
+		// stalled transfer is cut by timeout
+
+		d.Attr.RemoteFiles = []string{binaryPath}
+		d.Attr.CommandMatchTimeout = time.Nanosecond
+		tab.UpdateDevice(d)
+
+		r = fetchOneRepo(t, tab, logger, id, appConfig, repo)
+		if r.Code != fetchErrCommands || !strings.Contains(r.Msg, "timed out") {
+			t.Errorf("%s timeout: code=%d msg=%s", transport, r.Code, r.Msg)
+		}
+
+		d.Attr.CommandMatchTimeout = 0
+
+		// missing file
+
+		d.Attr.RemoteFiles = []string{filepath.Join(dir, "missing")}
+		tab.UpdateDevice(d)
+
+		r = fetchOneRepo(t, tab, logger, id, appConfig, repo)
+		if r.Code != fetchErrCommands {
+			t.Errorf("%s missing: code=%d msg=%s", transport, r.Code, r.Msg)
+		}
+
+		// file too large
+
+		d.Attr.RemoteFiles = []string{binaryPath}
+		d.Attr.MaxCaptureSize = 100
+		tab.UpdateDevice(d)
+
+		r = fetchOneRepo(t, tab, logger, id, appConfig, repo)
+		if r.Code != fetchErrCapture {
+			t.Errorf("%s too large: code=%d msg=%s", transport, r.Code, r.Msg)
+		}
+
+		// multiple files too large together
+
+		d.Attr.RemoteFiles = []string{textPath, textPath, textPath}
+		d.Attr.MaxCaptureSize = int64(len(text)*2 + 1)
+		tab.UpdateDevice(d)
+
+		r = fetchOneRepo(t, tab, logger, id, appConfig, repo)
+		if r.Code != fetchErrCapture {
+			t.Errorf("%s total too large: code=%d msg=%s", transport, r.Code, r.Msg)
+		}
+
+		temp.CleanupTempRepo()
+	}
+
+	s.close() // shutdown server
+
+	<-s.done // wait termination of accept loop goroutine
+}
@@ -0,0 +1,210 @@
+package dev
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/udhos/jazigo/store"
+)
+
+// Host key checking modes for DevConfig.SSHHostKeyCheck.
+const (
+	hostKeyTOFU   = "tofu"   // learn unknown keys, reject changed keys (default)
+	hostKeyStrict = "strict" // reject unknown and changed keys
+	hostKeyAccept = "accept" // accept any key (insecure)
+)
+
+const hostKeyMaxFileSize = 10000000 // 10M limit for known_hosts file
+
+var hostKeyLock sync.Mutex                      // serializes access to known_hosts file and offered keys
+var hostKeyOffered = map[string]ssh.PublicKey{} // host => key offered by last mismatch
+
+type hostKeyChangedError struct {
+	host    string
+	stored  ssh.PublicKey
+	offered ssh.PublicKey
+}
+
+func (e *hostKeyChangedError) Error() string {
+	return fmt.Sprintf("host key changed: host=%s stored=%s offered=%s - possible man-in-the-middle, re-accept key from device window if change is legitimate",
+		e.host, ssh.FingerprintSHA256(e.stored), ssh.FingerprintSHA256(e.offered))
+}
+
+type hostKeyUnknownError struct {
+	host    string
+	offered ssh.PublicKey
+}
+
+func (e *hostKeyUnknownError) Error() string {
+	return fmt.Sprintf("host key unknown: host=%s offered=%s - strict mode refuses to learn new keys, accept key from device window",
+		e.host, ssh.FingerprintSHA256(e.offered))
+}
+
+// HostKeyPath builds the full pathname for the known_hosts file kept in the repository.
+func HostKeyPath(repository string) string {
+	return filepath.Join(repository, "known_hosts")
+}
+
+func hostKeyHost(hostPort string) string {
+	return knownhosts.Normalize(forceHostPort(hostPort, "22"))
+}
+
+// hostKeyLoad reads the known_hosts file as a list of lines.
+// Missing file is reported as empty list.
+func hostKeyLoad(path string) ([][]byte, error) {
+	b, readErr := store.FileRead(path, hostKeyMaxFileSize)
+	if readErr != nil {
+		if !store.FileExists(path) {
+			return nil, nil
+		}
+		return nil, readErr
+	}
+	var lines [][]byte
+	for _, line := range bytes.Split(b, []byte{LF}) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+func hostKeyLineMatch(line []byte, host string) (ssh.PublicKey, bool) {
+	marker, hosts, key, _, _, err := ssh.ParseKnownHosts(line)
+	if err != nil || marker != "" {
+		return nil, false
+	}
+	for _, h := range hosts {
+		if h == host {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func hostKeyLookup(path, host string) (ssh.PublicKey, error) {
+	lines, loadErr := hostKeyLoad(path)
+	if loadErr != nil {
+		return nil, loadErr
+	}
+	for _, line := range lines {
+		if key, found := hostKeyLineMatch(line, host); found {
+			return key, nil
+		}
+	}
+	return nil, nil // not found
+}
+
+// hostKeySave replaces any key stored for host.
+// nil key removes the host from the file.
+func hostKeySave(path, host string, key ssh.PublicKey) error {
+	lines, loadErr := hostKeyLoad(path)
+	if loadErr != nil {
+		return loadErr
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if _, found := hostKeyLineMatch(line, host); found {
+			continue // drop old key
+		}
+		buf.Write(line)
+		buf.WriteByte(LF)
+	}
+
+	if key != nil {
+		buf.WriteString(knownhosts.Line([]string{host}, key))
+		buf.WriteByte(LF)
+	}
+
+	return store.FileWrite(path, buf.Bytes())
+}
+
+func hostKeyCallback(logger hasPrintf, path, mode, devLabel string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+
+		if mode == hostKeyAccept {
+			return nil
+		}
+
+		host := knownhosts.Normalize(hostname)
+
+		hostKeyLock.Lock()
+		defer hostKeyLock.Unlock()
+
+		stored, lookupErr := hostKeyLookup(path, host)
+		if lookupErr != nil {
+			return fmt.Errorf("hostKeyCheck: %s: could not read '%s': %v", devLabel, path, lookupErr)
+		}
+
+		if stored != nil {
+			if bytes.Equal(stored.Marshal(), key.Marshal()) {
+				delete(hostKeyOffered, host)
+				return nil // known key
+			}
+			hostKeyOffered[host] = key
+			return &hostKeyChangedError{host: host, stored: stored, offered: key}
+		}
+
+		switch mode {
+		case "", hostKeyTOFU:
+		case hostKeyStrict:
+			hostKeyOffered[host] = key
+			return &hostKeyUnknownError{host: host, offered: key}
+		default:
+			return fmt.Errorf("hostKeyCheck: %s: unknown host key check mode: '%s'", devLabel, mode)
+		}
+
+		// trust on first use
+
+		if saveErr := hostKeySave(path, host, key); saveErr != nil {
+			return fmt.Errorf("hostKeyCheck: %s: could not save '%s': %v", devLabel, path, saveErr)
+		}
+
+		logger.Printf("hostKeyCheck: %s: learned host key: host=%s key=%s", devLabel, host, ssh.FingerprintSHA256(key))
+
+		return nil
+	}
+}
+
+// DeviceHostKey retrieves both the stored host key and the key offered by the last mismatch, if any.
+func DeviceHostKey(repository, hostPort string) (string, string, error) {
+	host := hostKeyHost(hostPort)
+
+	hostKeyLock.Lock()
+	defer hostKeyLock.Unlock()
+
+	var stored, offered string
+
+	key, lookupErr := hostKeyLookup(HostKeyPath(repository), host)
+	if key != nil {
+		stored = key.Type() + " " + ssh.FingerprintSHA256(key)
+	}
+	if k, found := hostKeyOffered[host]; found {
+		offered = k.Type() + " " + ssh.FingerprintSHA256(k)
+	}
+
+	return stored, offered, lookupErr
+}
+
+// AcceptHostKey stores the key offered by the last mismatch.
+// If no key was offered, the stored key is forgotten and the next fetch will learn it again.
+func AcceptHostKey(repository, hostPort string) error {
+	host := hostKeyHost(hostPort)
+
+	hostKeyLock.Lock()
+	defer hostKeyLock.Unlock()
+
+	key := hostKeyOffered[host] // nil removes stored key
+	if err := hostKeySave(HostKeyPath(repository), host, key); err != nil {
+		return err
+	}
+	delete(hostKeyOffered, host)
+
+	return nil
+}
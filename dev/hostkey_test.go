@@ -0,0 +1,104 @@
+package dev
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/udhos/jazigo/temp"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, genErr := ed25519.GenerateKey(rand.Reader)
+	if genErr != nil {
+		t.Fatalf("newTestHostKey: %v", genErr)
+	}
+	key, keyErr := ssh.NewPublicKey(pub)
+	if keyErr != nil {
+		t.Fatalf("newTestHostKey: %v", keyErr)
+	}
+	return key
+}
+
+func TestHostKeyTOFU(t *testing.T) {
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	logger := &testLogger{t}
+	path := HostKeyPath(repo)
+	key1 := newTestHostKey(t)
+	key2 := newTestHostKey(t)
+
+	check := hostKeyCallback(logger, path, "", "test")
+
+	if err := check("router:22", nil, key1); err != nil {
+		t.Errorf("first use: unexpected error: %v", err)
+	}
+	if err := check("router:22", nil, key1); err != nil {
+		t.Errorf("known key: unexpected error: %v", err)
+	}
+
+	err := check("router:22", nil, key2)
+	var changedErr *hostKeyChangedError
+	if !errors.As(err, &changedErr) {
+		t.Errorf("changed key: expected hostKeyChangedError, got: %v", err)
+	}
+
+	stored, offered, infoErr := DeviceHostKey(repo, "router")
+	if infoErr != nil {
+		t.Errorf("DeviceHostKey: %v", infoErr)
+	}
+	if stored != key1.Type()+" "+ssh.FingerprintSHA256(key1) {
+		t.Errorf("DeviceHostKey: stored=%s", stored)
+	}
+	if offered != key2.Type()+" "+ssh.FingerprintSHA256(key2) {
+		t.Errorf("DeviceHostKey: offered=%s", offered)
+	}
+
+	if acceptErr := AcceptHostKey(repo, "router"); acceptErr != nil {
+		t.Errorf("AcceptHostKey: %v", acceptErr)
+	}
+	if err := check("router:22", nil, key2); err != nil {
+		t.Errorf("re-accepted key: unexpected error: %v", err)
+	}
+	if err := check("router:22", nil, key1); err == nil {
+		t.Errorf("old key: unexpected success")
+	}
+
+	// other port is another host
+	if err := check("router:2222", nil, key1); err != nil {
+		t.Errorf("other port: unexpected error: %v", err)
+	}
+}
+
+func TestHostKeyStrict(t *testing.T) {
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	logger := &testLogger{t}
+	path := HostKeyPath(repo)
+	key := newTestHostKey(t)
+
+	strict := hostKeyCallback(logger, path, hostKeyStrict, "test")
+
+	err := strict("switch:22", nil, key)
+	var unknownErr *hostKeyUnknownError
+	if !errors.As(err, &unknownErr) {
+		t.Errorf("strict unknown: expected hostKeyUnknownError, got: %v", err)
+	}
+
+	if acceptErr := AcceptHostKey(repo, "switch:22"); acceptErr != nil {
+		t.Errorf("AcceptHostKey: %v", acceptErr)
+	}
+	if err := strict("switch:22", nil, key); err != nil {
+		t.Errorf("strict accepted: unexpected error: %v", err)
+	}
+
+	accept := hostKeyCallback(logger, path, hostKeyAccept, "test")
+	if err := accept("switch:22", nil, newTestHostKey(t)); err != nil {
+		t.Errorf("accept mode: unexpected error: %v", err)
+	}
+}
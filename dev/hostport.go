@@ -0,0 +1,67 @@
+package dev
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// splitHostPort splits host[:port], [host][:port] or a bare IPv6 literal.
+// Port is empty when missing. IPv6 literals need brackets to carry a port.
+func splitHostPort(hostPort string) (string, string) {
+	if host, port, err := net.SplitHostPort(hostPort); err == nil {
+		return host, port
+	}
+	if strings.HasPrefix(hostPort, "[") && strings.HasSuffix(hostPort, "]") {
+		return hostPort[1 : len(hostPort)-1], "" // [2001:db8::1]
+	}
+	return hostPort, "" // host, 10.0.0.1 or 2001:db8::1
+}
+
+// forceHostPort adds defaultPort when hostPort has no port.
+func forceHostPort(hostPort, defaultPort string) string {
+	host, port := splitHostPort(hostPort)
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// NormalizeHostPort validates a device address and brings it to canonical form:
+// host, host:port, [ipv6] or [ipv6]:port.
+// Bare IPv6 literals are bracketed, so a default port can be appended later.
+func NormalizeHostPort(hostPort string) (string, error) {
+	hostPort = strings.TrimSpace(hostPort)
+	if hostPort == "" {
+		return "", fmt.Errorf("NormalizeHostPort: empty address")
+	}
+
+	host, port := splitHostPort(hostPort)
+
+	if host == "" {
+		return "", fmt.Errorf("NormalizeHostPort: missing host: '%s'", hostPort)
+	}
+	if strings.ContainsAny(host, "[] \t") {
+		return "", fmt.Errorf("NormalizeHostPort: bad host: '%s'", hostPort)
+	}
+	if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+		return "", fmt.Errorf("NormalizeHostPort: bad IPv6 address: '%s'", hostPort)
+	}
+	if strings.HasPrefix(hostPort, "[") && !strings.Contains(host, ":") {
+		return "", fmt.Errorf("NormalizeHostPort: brackets allowed only for IPv6 address: '%s'", hostPort)
+	}
+
+	if port == "" {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]", nil
+		}
+		return host, nil
+	}
+
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("NormalizeHostPort: bad port: '%s'", hostPort)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
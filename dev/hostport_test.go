@@ -0,0 +1,94 @@
+package dev
+
+import (
+	"net"
+	"testing"
+)
+
+func TestForceHostPort(t *testing.T) {
+	table := []struct {
+		input string
+		want  string
+	}{
+		{"host", "host:22"},
+		{"host:2222", "host:2222"},
+		{"10.0.0.1", "10.0.0.1:22"},
+		{"10.0.0.1:2222", "10.0.0.1:2222"},
+		{"2001:db8::1", "[2001:db8::1]:22"},
+		{"[2001:db8::1]", "[2001:db8::1]:22"},
+		{"[2001:db8::1]:2222", "[2001:db8::1]:2222"},
+		{"::1", "[::1]:22"},
+		{"fe80::1%eth0", "[fe80::1%eth0]:22"},
+	}
+	for _, e := range table {
+		if got := forceHostPort(e.input, "22"); got != e.want {
+			t.Errorf("forceHostPort(%q): got=%q want=%q", e.input, got, e.want)
+		}
+	}
+}
+
+func TestNormalizeHostPort(t *testing.T) {
+	table := []struct {
+		input string
+		want  string
+		bad   bool
+	}{
+		{input: " host ", want: "host"},
+		{input: "host:2001", want: "host:2001"},
+		{input: "2001:db8::1", want: "[2001:db8::1]"},
+		{input: "[2001:db8::1]", want: "[2001:db8::1]"},
+		{input: "[2001:DB8::1]:830", want: "[2001:DB8::1]:830"},
+		{input: "", bad: true},
+		{input: ":22", bad: true},
+		{input: "host:0", bad: true},
+		{input: "host:70000", bad: true},
+		{input: "host:ssh", bad: true},
+		{input: "[host]:22", bad: true},
+		{input: "[10.0.0.1]", bad: true},
+		{input: "2001:db8::zz", bad: true},
+		{input: "[2001:db8::1", bad: true},
+	}
+	for _, e := range table {
+		got, err := NormalizeHostPort(e.input)
+		if e.bad {
+			if err == nil {
+				t.Errorf("NormalizeHostPort(%q): unexpected success: %q", e.input, got)
+			}
+			continue
+		}
+		if err != nil || got != e.want {
+			t.Errorf("NormalizeHostPort(%q): got=%q error=%v want=%q", e.input, got, err, e.want)
+		}
+	}
+}
+
+func TestIPv6Telnet(t *testing.T) {
+
+	ln, listenErr := net.Listen("tcp6", "[::1]:0")
+	if listenErr != nil {
+		t.Skipf("IPv6 loopback not available: %v", listenErr)
+	}
+	ln.Close()
+
+	// launch bogus test server
+	addr := "[::1]:2042"
+	s, serverErr := spawnServerCiscoIOS(t, addr, optionsCiscoIOS{sendUsername: true, sendDisable: true, requestEnablePass: true})
+	if serverErr != nil {
+		t.Fatalf("could not spawn bogus CiscoIOS server: %v", serverErr)
+	}
+
+	// run client test
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "cisco-ios", "lab1", addr, "telnet", "lab", "pass", "en", false, nil)
+
+	r := fetchOne(t, tab, logger, "lab1")
+	if r.Code != fetchErrNone {
+		t.Errorf("ipv6: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	s.close() // shutdown server
+
+	<-s.done // wait termination of accept loop goroutine
+}
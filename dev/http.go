@@ -0,0 +1,176 @@
+package dev
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+// httpPinCheck accepts only the TLS certificate matching the SHA-256 fingerprint.
+// Colons and case are ignored: "AB:CD:..." matches "abcd...".
+func httpPinCheck(pin string) func(tls.ConnectionState) error {
+	want := strings.ToLower(strings.ReplaceAll(pin, ":", ""))
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) < 1 {
+			return fmt.Errorf("httpPinCheck: no peer certificate")
+		}
+		sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+		got := hex.EncodeToString(sum[:])
+		if got != want {
+			return fmt.Errorf("httpPinCheck: certificate fingerprint mismatch: got=%s pinned=%s", got, want)
+		}
+		return nil
+	}
+}
+
+// httpClient builds a client dialing thru the device dialer (proxy, jump hosts).
+func (d *Device) httpClient(dl *dialer) *http.Client {
+	tlsConfig := &tls.Config{}
+	if d.Attr.HTTPInsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if d.Attr.HTTPPinSHA256 != "" {
+		tlsConfig.InsecureSkipVerify = true // pinned certificate replaces CA verification
+		tlsConfig.VerifyConnection = httpPinCheck(d.Attr.HTTPPinSHA256)
+	}
+
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			reqDialer := *dl
+			reqDialer.ctx = ctx // request context, also cancelled with the fetch
+			return reqDialer.dial(addr)
+		},
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: dl.timeout,
+	}
+
+	return &http.Client{Transport: tr, Timeout: d.Attr.CommandMatchTimeout}
+}
+
+// httpURL resolves path against HTTPScheme://HostPort. Absolute URLs are kept.
+func (d *Device) httpURL(path string) (string, error) {
+	scheme := d.Attr.HTTPScheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	host, hostErr := NormalizeHostPort(d.HostPort) // brackets IPv6 literal
+	if hostErr != nil {
+		return "", hostErr
+	}
+	base := &url.URL{Scheme: scheme, Host: host, Path: "/"}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+func (d *Device) httpGet(ctx context.Context, client *http.Client, u string, maxSize int64) ([]byte, error) {
+	req, reqErr := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if reqErr != nil {
+		return nil, reqErr
+	}
+
+	for _, h := range d.Attr.HTTPHeaders {
+		req.Header.Set(h.Name, h.Value)
+	}
+
+	switch d.Attr.HTTPAuth {
+	case "":
+	case "basic":
+		req.SetBasicAuth(d.LoginUser, d.LoginPassword)
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+d.LoginPassword)
+	default:
+		return nil, fmt.Errorf("unknown http auth: '%s'", d.Attr.HTTPAuth)
+	}
+
+	resp, getErr := client.Do(req)
+	if getErr != nil {
+		return nil, getErr
+	}
+	defer resp.Body.Close()
+
+	body, readErr := readLimited(resp.Body, maxSize)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+
+	if d.Attr.HTTPJSONPretty && json.Valid(body) {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, body, "", "  "); err == nil {
+			buf.WriteByte('\n')
+			body = buf.Bytes()
+		}
+	}
+
+	return body, nil
+}
+
+// fetchHTTP collects Attr.HTTPURLs saving only response bodies.
+func (d *Device) fetchHTTP(ctx context.Context, logger hasPrintf, repository string, opt *conf.AppConfig, begin time.Time, ft *FilterTable) FetchResult {
+	modelName := d.devModel.name
+	transport := d.Attr.HTTPScheme
+
+	dl, _, _, optErr := d.connectOptions(ctx, logger, repository, opt)
+	if optErr != nil {
+		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("fetch http: %v", optErr), Code: fetchErrTransp, Begin: begin}
+	}
+
+	client := d.httpClient(dl)
+	defer client.CloseIdleConnections()
+
+	capture := dialog{}
+
+	if openErr := d.saveOpen(logger, &capture, repository, ft); openErr != nil {
+		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("save open: %v", openErr), Code: fetchErrSave, Begin: begin}
+	}
+
+	defer d.saveRollback(logger, &capture) // no-op after commit
+
+	for _, p := range d.Attr.HTTPURLs {
+		u, urlErr := d.httpURL(p)
+		if urlErr != nil {
+			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("fetch http: bad url '%s': %v", p, urlErr), Code: fetchErrCommands, Begin: begin}
+		}
+
+		body, getErr := d.httpGet(ctx, client, u, d.Attr.MaxCaptureSize)
+		if getErr != nil {
+			code := fetchErrCommands
+			var reqErr *url.Error
+			switch {
+			case errors.Is(getErr, errCaptureSize):
+				code = fetchErrCapture
+			case errors.As(getErr, &reqErr):
+				code = fetchErrTransp // could not connect, bad certificate, etc
+			}
+			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("fetch http: %s: %v", u, getErr), Code: code, Begin: begin}
+		}
+
+		if saveErr := d.save(logger, &capture, u, body); saveErr != nil {
+			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("fetch http: %s: %v", u, saveErr), Code: fetchCaptureCode(&capture, fetchErrSave), Begin: begin}
+		}
+	}
+
+	if saveErr := d.saveCommit(logger, &capture, opt.MaxConfigFiles, d.shrinkGuard(opt)); saveErr != nil {
+		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("save commit: %v", saveErr), Code: saveErrCode(saveErr), Begin: begin}
+	}
+
+	return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Code: fetchErrNone, Begin: begin}
+}
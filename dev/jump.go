@@ -0,0 +1,226 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+// dialer opens the raw connection to the device, either directly or tunnelled thru a chain of jump hosts.
+// The proxy, if any, is used to reach the device or the first jump host.
+type dialer struct {
+	netDialer
+	logger      hasPrintf
+	devLabel    string
+	proxy       proxyDialer
+	jumps       []conf.JumpHost
+	hostKeyPath string // known_hosts file for jump host keys
+}
+
+func (dl *dialer) dial(hostPort string) (net.Conn, error) {
+	if len(dl.jumps) < 1 {
+		return dl.proxy.dial(&dl.netDialer, hostPort)
+	}
+	return dialJump(dl.logger, dl.devLabel, dl.proxy, &dl.netDialer, dl.jumps, hostPort, dl.hostKeyPath)
+}
+
+// jumpChain holds the ssh clients for every hop.
+type jumpChain struct {
+	clients []*ssh.Client
+}
+
+// close shuts down hops from last to first.
+func (j *jumpChain) close() {
+	for i := len(j.clients) - 1; i >= 0; i-- {
+		j.clients[i].Close()
+	}
+	j.clients = nil
+}
+
+// guard closes the hops open so far, and conn if not nil, when a step on them outlasts nd.timeout or nd.ctx is cancelled.
+// ssh channels ignore deadlines, so closing is the only way to interrupt a stalled hop.
+// The returned stop reports false if the step was interrupted.
+func (j *jumpChain) guard(nd *netDialer, conn net.Conn) func() bool {
+	clients := append([]*ssh.Client(nil), j.clients...)
+	var fired atomic.Bool
+	abort := func() {
+		if fired.Swap(true) {
+			return
+		}
+		if conn != nil {
+			conn.Close()
+		}
+		for i := len(clients) - 1; i >= 0; i-- {
+			clients[i].Close()
+		}
+	}
+
+	stopTimer := func() bool { return true }
+	if nd.timeout > 0 {
+		stopTimer = time.AfterFunc(nd.timeout, abort).Stop
+	}
+	stopCtx := func() bool { return true }
+	if nd.ctx != nil {
+		stopCtx = context.AfterFunc(nd.ctx, abort)
+	}
+
+	return func() bool {
+		stopTimer()
+		stopCtx()
+		return !fired.Load()
+	}
+}
+
+// interrupted builds the error for a step cut by guard.
+func (nd *netDialer) interrupted(err error) error {
+	if nd.ctx != nil && nd.ctx.Err() != nil {
+		return fmt.Errorf("%w: %v", nd.ctx.Err(), err)
+	}
+	return fmt.Errorf("timed out: %s: %v", nd.timeout, err)
+}
+
+// jumpConn is the local end of a pipe connected to a direct-tcpip channel.
+// ssh channels do not support deadlines, hence the pipe.
+type jumpConn struct {
+	net.Conn
+	chain *jumpChain
+	once  sync.Once
+}
+
+func (c *jumpConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.chain.close)
+	return err
+}
+
+// dialJump connects to hostPort thru a direct-tcpip channel opened on the last hop.
+// Each hop is reached thru a direct-tcpip channel opened on the previous one.
+func dialJump(logger hasPrintf, devLabel string, proxy proxyDialer, nd *netDialer, jumps []conf.JumpHost, hostPort string, hostKeyPath string) (net.Conn, error) {
+
+	chain := &jumpChain{}
+
+	for i, j := range jumps {
+		hop := forceHostPort(j.HostPort, "22")
+		hopLabel := fmt.Sprintf("%s hop %d/%d %s", devLabel, i+1, len(jumps), hop)
+
+		var conn net.Conn
+		var dialErr error
+		if i == 0 {
+			conn, dialErr = proxy.dial(nd, hop) // bounded by netDialer
+		} else {
+			stop := chain.guard(nd, nil)
+			conn, dialErr = chain.clients[i-1].Dial("tcp", hop)
+			if !stop() {
+				if dialErr == nil {
+					conn.Close()
+				}
+				dialErr = nd.interrupted(dialErr)
+			}
+		}
+		if dialErr != nil {
+			chain.close()
+			return nil, fmt.Errorf("dialJump: %s - dial: %v", hopLabel, dialErr)
+		}
+
+		stop := chain.guard(nd, conn)
+		cli, connErr := jumpClient(logger, hopLabel, conn, hop, j, nd.timeout, hostKeyPath)
+		if !stop() {
+			if connErr == nil {
+				cli.Close()
+			}
+			connErr = fmt.Errorf("dialJump: %s - handshake: %v", hopLabel, nd.interrupted(connErr))
+		}
+		if connErr != nil {
+			conn.Close()
+			chain.close()
+			return nil, connErr
+		}
+
+		chain.clients = append(chain.clients, cli)
+	}
+
+	last := chain.clients[len(chain.clients)-1]
+
+	stop := chain.guard(nd, nil)
+	remote, dialErr := last.Dial("tcp", hostPort)
+	if !stop() {
+		if dialErr == nil {
+			remote.Close()
+		}
+		dialErr = nd.interrupted(dialErr)
+	}
+	if dialErr != nil {
+		chain.close()
+		return nil, fmt.Errorf("dialJump: %s - direct-tcpip to %s: %v", devLabel, hostPort, dialErr)
+	}
+
+	local, peer := net.Pipe()
+
+	go jumpCopy(peer, remote)
+	go jumpCopy(remote, peer)
+
+	logger.Printf("dialJump: %s - tunnel open thru %d hop(s)", devLabel, len(jumps))
+
+	return &jumpConn{Conn: local, chain: chain}, nil
+}
+
+// jumpCopy pumps data from src to dst, then closes both.
+func jumpCopy(dst, src net.Conn) {
+	io.Copy(dst, src)
+	dst.Close()
+	src.Close()
+}
+
+func jumpClient(logger hasPrintf, hopLabel string, conn net.Conn, hop string, j conf.JumpHost, timeout time.Duration, hostKeyPath string) (*ssh.Client, error) {
+
+	auth := &sshAuthOptions{
+		user:          j.LoginUser,
+		password:      j.LoginPassword,
+		keyFile:       j.SSHKeyFile,
+		keyPassphrase: j.SSHKeyPassphrase,
+		agent:         j.SSHAgent,
+	}
+
+	methods, tracker := sshAuthMethods(logger, hopLabel, auth)
+	defer tracker.close()
+
+	config := &ssh.ClientConfig{
+		User:            auth.user,
+		Auth:            methods,
+		Timeout:         timeout,
+		HostKeyCallback: hostKeyCallback(logger, hostKeyPath, j.SSHHostKeyCheck, hopLabel),
+	}
+
+	// handshake does not honor config.Timeout on already established connections: dialJump guards it
+	c, chans, reqs, connErr := ssh.NewClientConn(conn, hop, config)
+	if connErr != nil {
+		return nil, fmt.Errorf("dialJump: %s - NewClientConn: %w", hopLabel, connErr)
+	}
+
+	logger.Printf("dialJump: %s - authenticated: method=%s", hopLabel, tracker.last)
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// jumpHosts selects the jump host chain for the device: its own JumpHosts, else the named JumpGroup.
+func jumpHosts(c *conf.DevConfig, groups map[string][]conf.JumpHost) ([]conf.JumpHost, error) {
+	if len(c.JumpHosts) > 0 {
+		return c.JumpHosts, nil
+	}
+	if c.JumpGroup == "" {
+		return nil, nil
+	}
+	jumps, found := groups[c.JumpGroup]
+	if !found {
+		return nil, fmt.Errorf("jumpHosts: unknown jump group: '%s'", c.JumpGroup)
+	}
+	return jumps, nil
+}
@@ -0,0 +1,165 @@
+package dev
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+func spawnJumpHost(t *testing.T, addr, user, pass string) *testServer {
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if c.User() == user && string(p) == pass {
+				return &ssh.Permissions{Extensions: map[string]string{"auth": "password"}}, nil
+			}
+			return nil, fmt.Errorf("bad password")
+		},
+	}
+	s, listenErr := spawnServerSSH(t, addr, config)
+	if listenErr != nil {
+		t.Fatalf("could not spawn bogus jump host: %v", listenErr)
+	}
+	return s
+}
+
+func TestJumpTelnet(t *testing.T) {
+
+	// launch bogus test servers
+	jump1 := spawnJumpHost(t, ":2031", "bastion1", "secret1")
+	jump2 := spawnJumpHost(t, ":2032", "bastion2", "secret2")
+
+	addr := ":2033"
+	s, listenErr := spawnServerCiscoIOS(t, addr, optionsCiscoIOS{sendUsername: true, sendDisable: true, requestEnablePass: true})
+	if listenErr != nil {
+		t.Errorf("could not spawn bogus CiscoIOS server: %v", listenErr)
+	}
+
+	// run client test
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "cisco-ios", "lab1", "localhost"+addr, "telnet", "lab", "pass", "en", false, nil)
+
+	d, _ := tab.GetDevice("lab1")
+	d.JumpHosts = []conf.JumpHost{
+		{HostPort: "localhost:2031", LoginUser: "bastion1", LoginPassword: "secret1"},
+		{HostPort: "localhost:2032", LoginUser: "bastion2", LoginPassword: "secret2"},
+	}
+	tab.UpdateDevice(d)
+
+	r := fetchOne(t, tab, logger, "lab1")
+	if r.Code != fetchErrNone {
+		t.Errorf("telnet thru 2 hops: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	// wrong password on second hop
+	d.JumpHosts[1].LoginPassword = "wrong"
+	tab.UpdateDevice(d)
+
+	r = fetchOne(t, tab, logger, "lab1")
+	if r.Code != fetchErrTransp {
+		t.Errorf("bad hop password: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	s.close()
+	jump2.close()
+	jump1.close()
+
+	<-s.done
+	<-jump2.done
+	<-jump1.done
+}
+
+func TestJumpGroupSSH(t *testing.T) {
+
+	// launch bogus test servers
+	jump := spawnJumpHost(t, ":2034", "bastion", "secret")
+
+	addr := ":2035"
+	s := spawnJumpHost(t, addr, "lab", "pass") // plain ssh shell server
+
+	// run client test
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "linux", "lab1", "localhost"+addr, "ssh", "lab", "pass", "", false, nil)
+
+	d, _ := tab.GetDevice("lab1")
+	d.JumpGroup = "oob"
+	tab.UpdateDevice(d)
+
+	groups := map[string][]conf.JumpHost{
+		"oob": {{HostPort: "localhost:2034", LoginUser: "bastion", LoginPassword: "secret"}},
+	}
+
+	jumps, jumpErr := jumpHosts(&d.DevConfig, groups)
+	if jumpErr != nil || len(jumps) != 1 {
+		t.Errorf("jump group: hops=%d error=%v", len(jumps), jumpErr)
+	}
+
+	r := fetchOneOpt(t, tab, logger, "lab1", &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10, JumpGroups: groups})
+	if r.Code != fetchErrNone {
+		t.Errorf("ssh thru jump group: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	// unknown group
+	r = fetchOne(t, tab, logger, "lab1")
+	if r.Code != fetchErrTransp {
+		t.Errorf("unknown jump group: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	s.close()
+	jump.close()
+
+	<-s.done
+	<-jump.done
+}
+
+func TestJumpHopTimeout(t *testing.T) {
+
+	// launch bogus test servers: second hop accepts tcp but never speaks ssh
+	jump := spawnJumpHost(t, ":2055", "bastion", "secret")
+
+	addr := ":2056"
+	ln, listenErr := net.Listen("tcp", addr)
+	if listenErr != nil {
+		t.Fatalf("could not spawn hanging server: %v", listenErr)
+	}
+	s := &testServer{listener: ln, done: make(chan int)}
+	go acceptLoop(t, s, handleConnectionHang, optionsCiscoIOS{})
+
+	// run client test
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "cisco-ios", "lab1", "localhost:2057", "telnet", "lab", "pass", "en", false, nil)
+
+	d, _ := tab.GetDevice("lab1")
+	d.DialTimeout = 300 * time.Millisecond
+	d.JumpHosts = []conf.JumpHost{
+		{HostPort: "localhost:2055", LoginUser: "bastion", LoginPassword: "secret"},
+		{HostPort: "localhost" + addr, LoginUser: "bastion", LoginPassword: "secret"},
+	}
+	tab.UpdateDevice(d)
+
+	begin := time.Now()
+	r := fetchOne(t, tab, logger, "lab1")
+	if r.Code != fetchErrTransp || !strings.Contains(r.Msg, "timed out") {
+		t.Errorf("stalled hop: code=%d msg=%s", r.Code, r.Msg)
+	}
+	if elap := time.Since(begin); elap > 5*time.Second {
+		t.Errorf("stalled hop: took too long: %s", elap)
+	}
+
+	s.close()
+	jump.close()
+
+	<-s.done
+	<-jump.done
+}
@@ -2,12 +2,16 @@ package dev
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"path/filepath"
 	"regexp"
 	"time"
 
+	"golang.org/x/crypto/ssh"
+
 	"github.com/udhos/jazigo/conf"
 	"github.com/udhos/jazigo/store"
 )
@@ -16,6 +20,7 @@ import (
 type Model struct {
 	name        string
 	defaultAttr conf.DevAttributes
+	source      string // declarative model: file or config section that defined it - empty for built-in model
 }
 
 // Device is an specific device.
@@ -28,6 +33,7 @@ type Device struct {
 	lastTry     time.Time
 	lastSuccess time.Time
 	lastElapsed time.Duration
+	quarantined bool // suspect backup held by the shrink guard, until accepted or discarded
 }
 
 // Username gets the username for login into a device.
@@ -46,6 +52,11 @@ func (d *Device) Model() string {
 	return d.devModel.name
 }
 
+// Quarantined reports whether a suspect backup is held by the shrink guard.
+func (d *Device) Quarantined() bool {
+	return d.quarantined
+}
+
 // LastStatus gets a status string for last configuration backup.
 func (d *Device) LastStatus() bool {
 	return d.lastStatus
@@ -84,7 +95,9 @@ func RegisterModels(logger hasPrintf, t *DeviceTable) {
 	registerModelJunOS(logger, t)
 	registerModelLinux(logger, t)
 	registerModelMikrotik(logger, t)
+	registerModelNetconf(logger, t)
 	registerModelRun(logger, t)
+	registerModelSFTP(logger, t)
 }
 
 // CreateDevice creates a new device in the device table.
@@ -120,6 +133,7 @@ func NewDeviceFromConf(tab *DeviceTable, logger hasPrintf, cfg *conf.DevConfig)
 		return nil, fmt.Errorf("NewDeviceFromConf: could not find model '%s': %v", cfg.Model, getErr)
 	}
 	d := &Device{logger: logger, devModel: mod, DevConfig: *cfg}
+	httpLegacyMigrate(logger, &d.DevConfig)
 	return d, nil
 }
 
@@ -139,12 +153,20 @@ const (
 	fetchErrPager    = 5
 	fetchErrCommands = 6
 	fetchErrSave     = 7
+	fetchErrHostKey  = 8
+	fetchErrExit     = 9
+	fetchErrCapture  = 10
+	fetchErrOutput   = 11
+	fetchErrTrunc    = 12
+	fetchErrShrink   = 13
+	fetchErrAbort    = 14
 )
 
 // FetchRequest is a request for fetching a device configuration.
 type FetchRequest struct {
 	ID        string           // fetch this device
 	ReplyChan chan FetchResult // reply on this channel
+	Ctx       context.Context  // cancels this request, the fetch once all requests joined to it are cancelled; nil holds the fetch until the end
 }
 
 // FetchResult reports the result for fetching a device configuration.
@@ -153,6 +175,7 @@ type FetchResult struct {
 	DevID       string
 	DevHostPort string
 	Transport   string
+	Auth        string    // ssh auth method that succeeded
 	Msg         string    // result error message
 	Code        int       // result error code
 	Begin       time.Time // begin timestamp
@@ -163,15 +186,28 @@ type hasPrintf interface {
 	Printf(fmt string, v ...interface{})
 }
 
+// dialog streams command outputs into the store tmp file as they arrive.
 type dialog struct {
-	save [][]byte
+	out       *store.ConfigWriter // nil until saveOpen: login, enable and pager off output is not saved
+	filter    FilterFunc          // nil means no line filter
+	ft        *FilterTable
+	lineNum   int
+	size      int64          // raw output bytes saved so far
+	overflow  bool           // device output exceeded Attr.MaxCaptureSize
+	truncated []string       // commands whose output lacks the end marker
+	cmd       *commandOutput // output of the command being saved, nil outside saveBegin/saveEnd
 }
 
 // Fetch captures a configuration for a device.
 // Fetch runs in a per-device goroutine.
-func (d *Device) Fetch(tab DeviceUpdater, logger hasPrintf, resultCh chan FetchResult, delay time.Duration, repository, logPathPrefix string, opt *conf.AppConfig, ft *FilterTable) {
+func (d *Device) Fetch(ctx context.Context, tab DeviceUpdater, logger hasPrintf, resultCh chan FetchResult, delay time.Duration, repository, logPathPrefix string, opt *conf.AppConfig, ft *FilterTable) {
 
-	result := d.fetch(logger, delay, repository, opt.MaxConfigFiles, ft)
+	result := d.fetch(ctx, logger, delay, repository, logPathPrefix, opt, ft)
+
+	if result.Code != fetchErrNone && ctx.Err() != nil {
+		result.Msg = "aborted: " + result.Msg
+		result.Code = fetchErrAbort
+	}
 
 	result.End = time.Now()
 
@@ -179,6 +215,13 @@ func (d *Device) Fetch(tab DeviceUpdater, logger hasPrintf, resultCh chan FetchR
 
 	updateDeviceStatus(tab, d.ID, good, result.End, result.End.Sub(result.Begin), logger, opt.Holdtime)
 
+	switch {
+	case result.Code == fetchErrShrink:
+		SetDeviceQuarantine(tab, d.ID, true, logger)
+	case good && d.quarantined:
+		SetDeviceQuarantine(tab, d.ID, false, logger) // quarantine superseded by good backup
+	}
+
 	errlog(logger, result, logPathPrefix, d.Debug, d.Attr.ErrlogHistSize)
 
 	if resultCh != nil {
@@ -186,36 +229,126 @@ func (d *Device) Fetch(tab DeviceUpdater, logger hasPrintf, resultCh chan FetchR
 	}
 }
 
-func (d *Device) createTransport(logger hasPrintf) (transp, string, bool, error) {
+func (d *Device) createTransport(ctx context.Context, logger hasPrintf, repository string, opt *conf.AppConfig) (transp, string, bool, error) {
 	modelName := d.devModel.name
 
 	if modelName == "run" {
 		d.debugf("createTransport: %q", d.Attr.RunProg)
-		return openTransportPipe(logger, modelName, d.ID, d.HostPort, d.Transports, d.LoginUser,
-			d.LoginPassword, d.Attr.RunProg, d.Debug, d.Attr.RunTimeout)
+		return openTransportPipe(ctx, logger, modelName, d.ID, d.HostPort, d.Transports, d.LoginUser,
+			d.LoginPassword, d.Attr.RunProg, d.Debug, newPipeOptions(&d.Attr))
+	}
+
+	dl, auth, hostKeyCheck, optErr := d.connectOptions(ctx, logger, repository, opt)
+	if optErr != nil {
+		return nil, d.Transports, false, optErr
+	}
+
+	return openTransport(logger, modelName, d.ID, d.HostPort, d.Transports, dl, auth,
+		d.DevConfig.SSHClearCiphers, d.DevConfig.SSHAddCiphers, hostKeyCheck)
+}
+
+// connectOptions builds the dialer, ssh credentials and host key checker for the device.
+func (d *Device) connectOptions(ctx context.Context, logger hasPrintf, repository string, opt *conf.AppConfig) (*dialer, *sshAuthOptions, ssh.HostKeyCallback, error) {
+	devLabel := fmt.Sprintf("%s %s %s", d.devModel.name, d.ID, d.HostPort)
+	hostKeyCheck := hostKeyCallback(logger, HostKeyPath(repository), d.DevConfig.SSHHostKeyCheck, devLabel)
+
+	jumps, jumpErr := jumpHosts(&d.DevConfig, opt.JumpGroups)
+	if jumpErr != nil {
+		return nil, nil, nil, fmt.Errorf("connectOptions: %s - %v", devLabel, jumpErr)
+	}
+
+	proxy, proxyErr := newProxyDialer(selectProxy(d.DevConfig.Proxy, opt.Proxy))
+	if proxyErr != nil {
+		return nil, nil, nil, fmt.Errorf("connectOptions: %s - %v", devLabel, proxyErr)
+	}
+
+	nd, dialErr := newNetDialer(&d.DevConfig, opt)
+	if dialErr != nil {
+		return nil, nil, nil, fmt.Errorf("connectOptions: %s - %v", devLabel, dialErr)
+	}
+	nd.ctx = ctx
+
+	dl := &dialer{
+		netDialer:   nd,
+		logger:      logger,
+		devLabel:    devLabel,
+		proxy:       proxy,
+		jumps:       jumps,
+		hostKeyPath: HostKeyPath(repository),
+	}
+
+	auth := &sshAuthOptions{
+		user:          d.Username(),
+		password:      d.LoginPassword,
+		keyFile:       d.DevConfig.SSHKeyFile,
+		keyPassphrase: d.DevConfig.SSHKeyPassphrase,
+		agent:         d.DevConfig.SSHAgent,
+		agentSocket:   d.DevConfig.SSHAgentSocket,
+		challenges:    d.Attr.SSHChallenges,
 	}
 
-	return openTransport(logger, modelName, d.ID, d.HostPort, d.Transports, d.Username(),
-		d.LoginPassword, d.DevConfig.SSHClearCiphers, d.DevConfig.SSHAddCiphers)
+	return dl, auth, hostKeyCheck, nil
 }
 
-func (d *Device) fetch(logger hasPrintf, delay time.Duration, repository string, maxFiles int, ft *FilterTable) FetchResult {
+func (d *Device) fetch(ctx context.Context, logger hasPrintf, delay time.Duration, repository, logPathPrefix string, opt *conf.AppConfig, ft *FilterTable) (result FetchResult) {
 	modelName := d.devModel.name
 
+	begin := time.Now()
+
 	if delay > 0 {
-		time.Sleep(delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: d.Transports, Msg: fmt.Sprintf("fetch: %v", ctx.Err()), Code: fetchErrTransp, Begin: begin}
+		}
+		begin = time.Now()
 	}
 
-	begin := time.Now()
+	var rec *transpRecorder
+	if d.Attr.TranscriptCount > 0 {
+		var recErr error
+		if rec, recErr = d.openTranscript(logger, logPathPrefix, begin); recErr != nil {
+			logger.Printf("fetch: %s %s %s - transcript: %v", modelName, d.ID, d.HostPort, recErr)
+		} else {
+			defer func() { rec.finish(logger, result) }() // also records failures before the session
+		}
+	}
+
+	if len(d.Attr.RemoteFiles) > 0 {
+		return d.fetchFiles(ctx, logger, repository, opt, begin)
+	}
 
-	session, transport, logged, err := d.createTransport(logger)
+	if len(d.Attr.NetconfDatastores) > 0 {
+		return d.fetchNetconf(ctx, logger, repository, opt, begin)
+	}
+
+	if len(d.Attr.HTTPURLs) > 0 {
+		return d.fetchHTTP(ctx, logger, repository, opt, begin, ft)
+	}
+
+	session, transport, logged, err := d.createTransport(ctx, logger, repository, opt)
 	if err != nil {
-		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("fetch transport: %v", err), Code: fetchErrTransp, Begin: begin}
+		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("fetch transport: %v", err), Code: fetchTransportCode(err), Begin: begin}
 	}
 
 	defer session.Close()
 
-	logger.Printf("fetch: %s %s %s - transport OPEN logged=%v", modelName, d.ID, d.HostPort, logged)
+	stop := context.AfterFunc(ctx, func() { session.SetDeadline(time.Now()) }) // interrupt blocked read
+	defer stop()
+
+	var auth string
+	if s, isSSH := session.(*transpSSH); isSSH {
+		auth = s.auth
+	}
+
+	transpSession := session // unwrapped transport
+
+	if rec != nil {
+		rec.attach(session, transport)
+		session = rec
+	}
+
+	logger.Printf("fetch: %s %s %s - transport OPEN logged=%v auth=%s", modelName, d.ID, d.HostPort, logged, auth)
 
 	capture := dialog{}
 
@@ -224,9 +357,9 @@ func (d *Device) fetch(logger hasPrintf, delay time.Duration, repository string,
 	d.debugf("will login")
 
 	if d.Attr.NeedLoginChat && !logged {
-		e, loginErr := d.login(logger, session, &capture)
+		e, loginErr := d.login(ctx, logger, session, &capture)
 		if loginErr != nil {
-			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("fetch login: %v", loginErr), Code: fetchErrLogin, Begin: begin}
+			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Auth: auth, Msg: fmt.Sprintf("fetch login: %v", loginErr), Code: fetchCaptureCode(&capture, fetchErrLogin), Begin: begin}
 		}
 		if e {
 			enabled = true
@@ -236,40 +369,88 @@ func (d *Device) fetch(logger hasPrintf, delay time.Duration, repository string,
 	d.debugf("will enable")
 
 	if d.Attr.NeedEnabledMode && !enabled {
-		enableErr := d.enable(logger, session, &capture)
+		enableErr := d.enable(ctx, logger, session, &capture)
 		if enableErr != nil {
 			d.debugf("enable failed")
-			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("fetch enable: %v", enableErr), Code: fetchErrEnable, Begin: begin}
+			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Auth: auth, Msg: fmt.Sprintf("fetch enable: %v", enableErr), Code: fetchCaptureCode(&capture, fetchErrEnable), Begin: begin}
 		}
 	}
 
 	d.debugf("will disable paging: %v pattern=[%s]", d.Attr.NeedPagingOff, d.Attr.DisablePagerCommand)
 
 	if d.Attr.NeedPagingOff {
-		pagingErr := d.pagingOff(logger, session, &capture)
+		pagingErr := d.pagingOff(ctx, logger, session, &capture)
 		if pagingErr != nil {
-			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("fetch pager off: %v", pagingErr), Code: fetchErrPager, Begin: begin}
+			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Auth: auth, Msg: fmt.Sprintf("fetch pager off: %v", pagingErr), Code: fetchCaptureCode(&capture, fetchErrPager), Begin: begin}
 		}
 	}
 
 	d.debugf("will send commands")
 
-	if cmdErr := d.sendCommands(logger, session, &capture); cmdErr != nil {
+	if openErr := d.saveOpen(logger, &capture, repository, ft); openErr != nil {
+		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Auth: auth, Msg: fmt.Sprintf("save open: %v", openErr), Code: fetchErrSave, Begin: begin}
+	}
+
+	if cmdErr := d.sendCommands(ctx, logger, session, &capture); cmdErr != nil {
 		d.saveRollback(logger, &capture)
-		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("commands: %v", cmdErr), Code: fetchErrCommands, Begin: begin}
+		var outputErr *commandError
+		if errors.As(cmdErr, &outputErr) {
+			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Auth: auth, Msg: fmt.Sprintf("commands: %v", outputErr), Code: fetchErrOutput, Begin: begin}
+		}
+		if exitErr := pipeExitError(transpSession); exitErr != nil {
+			return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Auth: auth, Msg: fmt.Sprintf("commands: %v", exitErr), Code: fetchErrExit, Begin: begin}
+		}
+		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Auth: auth, Msg: fmt.Sprintf("commands: %v", cmdErr), Code: fetchCaptureCode(&capture, fetchErrCommands), Begin: begin}
 	}
 
 	d.debugf("will save results")
 
-	if saveErr := d.saveCommit(logger, &capture, repository, maxFiles, ft); saveErr != nil {
-		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("save commit: %v", saveErr), Code: fetchErrSave, Begin: begin}
+	if saveErr := d.saveCommit(logger, &capture, opt.MaxConfigFiles, d.shrinkGuard(opt)); saveErr != nil {
+		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Auth: auth, Msg: fmt.Sprintf("save commit: %v", saveErr), Code: saveErrCode(saveErr), Begin: begin}
 	}
 
-	return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Code: fetchErrNone, Begin: begin}
+	return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Auth: auth, Code: fetchErrNone, Begin: begin}
+}
+
+// fetchTransportCode reports host key failures apart from other transport errors.
+func fetchTransportCode(err error) int {
+	var changedErr *hostKeyChangedError
+	var unknownErr *hostKeyUnknownError
+	if errors.As(err, &changedErr) || errors.As(err, &unknownErr) {
+		return fetchErrHostKey
+	}
+	return fetchErrTransp
+}
+
+// fetchCaptureCode reports capture overflow apart from the error code of the failed step.
+func fetchCaptureCode(capture *dialog, code int) int {
+	if capture.overflow {
+		return fetchErrCapture
+	}
+	return code
+}
+
+// saveErrCode reports truncated and shrunk backups apart from other save errors.
+func saveErrCode(err error) int {
+	var truncErr *truncatedError
+	var shrinkErr *store.ShrinkError
+	switch {
+	case errors.As(err, &truncErr):
+		return fetchErrTrunc
+	case errors.As(err, &shrinkErr):
+		return fetchErrShrink
+	}
+	return fetchErrSave
 }
 
 func (d *Device) saveRollback(logger hasPrintf, capture *dialog) {
-	capture.save = nil
+	if capture.out == nil {
+		return
+	}
+	if err := capture.out.Discard(); err != nil {
+		logger.Printf("saveRollback: dev '%s': %v", d.ID, err)
+	}
+	capture.out = nil
 }
 
 func deviceDirectory(repository, id string) string {
@@ -296,63 +477,84 @@ func (d *Device) DevicePathPrefix(devDir string) string {
 	return filepath.Join(devDir, d.ID+".")
 }
 
-func (d *Device) saveCommit(logger hasPrintf, capture *dialog, repository string, maxFiles int, ft *FilterTable) error {
+// saveOpen creates the tmp file receiving command outputs.
+func (d *Device) saveOpen(logger hasPrintf, capture *dialog, repository string, ft *FilterTable) error {
 
 	devDir := d.DeviceDir(repository)
 
 	if mkdirErr := store.MkDir(devDir); mkdirErr != nil {
-		return fmt.Errorf("saveCommit: mkdir: error: %v", mkdirErr)
+		return fmt.Errorf("saveOpen: mkdir: error: %v", mkdirErr)
 	}
 
-	devPathPrefix := d.DevicePathPrefix(devDir)
-
-	// writeFunc: copy command outputs into file
-	writeFunc := func(w store.HasWrite) error {
+	w, openErr := store.NewConfigWriter(d.DevicePathPrefix(devDir), d.Attr.S3ContentType)
+	if openErr != nil {
+		return fmt.Errorf("saveOpen: %v", openErr)
+	}
 
-		lineFilter, filterFound := ft.table[d.Attr.LineFilter]
-		if filterFound {
-			d.debugf("saveCommit: filter '%s' FOUND", d.Attr.LineFilter)
-		} else {
-			if d.Attr.LineFilter != "" {
-				d.debugf("saveCommit: filter '%s' not found", d.Attr.LineFilter)
-			}
+	lineFilter, filterFound := ft.table[d.Attr.LineFilter]
+	if filterFound {
+		d.debugf("saveOpen: filter '%s' FOUND", d.Attr.LineFilter)
+	} else {
+		if d.Attr.LineFilter != "" {
+			d.debugf("saveOpen: filter '%s' not found", d.Attr.LineFilter)
 		}
+	}
 
-		lineNum := 1
+	capture.out = w
+	capture.filter = lineFilter
+	capture.ft = ft
+	capture.lineNum = 1
 
-		for _, b := range capture.save {
+	return nil
+}
 
-			var lines [][]byte
-			if filterFound {
-				lines = bytes.Split(b, []byte{'\n'}) // split block into lines
-			} else {
-				lines = [][]byte{b} // use block as single line
-			}
+// saveBlock copies a block of output into the tmp file, thru the line filter.
+func (d *Device) saveBlock(capture *dialog, b []byte) error {
 
-			for _, line := range lines {
+	var lines [][]byte
+	if capture.filter != nil {
+		lines = bytes.Split(b, []byte{'\n'}) // split block into lines
+	} else {
+		lines = [][]byte{b} // use block as single line
+	}
 
-				if filterFound {
-					line = lineFilter(d, d.Debug, ft, line, lineNum) // apply filter
-					line = append(line, '\n')                        // restore LF removed by split
-				}
+	for _, line := range lines {
 
-				n, writeErr := w.Write(line)
-				if writeErr != nil {
-					return fmt.Errorf("saveCommit: writeFunc: error: %v", writeErr)
-				}
-				if n != len(line) {
-					return fmt.Errorf("saveCommit: writeFunc: partial: wrote=%d size=%d", n, len(line))
-				}
+		if capture.filter != nil {
+			line = capture.filter(d, d.Debug, capture.ft, line, capture.lineNum) // apply filter
+			line = append(line, '\n')                                            // restore LF removed by split
+		}
 
-				lineNum++
-			}
+		n, writeErr := capture.out.Write(line)
+		if writeErr != nil {
+			return fmt.Errorf("saveBlock: error: %v", writeErr)
 		}
-		return nil
+		if n != len(line) {
+			return fmt.Errorf("saveBlock: partial: wrote=%d size=%d", n, len(line))
+		}
+
+		capture.lineNum++
+	}
+
+	return nil
+}
+
+// saveCommit turns the tmp file into the next config file.
+func (d *Device) saveCommit(logger hasPrintf, capture *dialog, maxFiles int, guard *store.ShrinkGuard) error {
+
+	if capture.out == nil {
+		return fmt.Errorf("saveCommit: tmp file not open")
 	}
 
-	path, writeErr := store.SaveNewConfig(devPathPrefix, maxFiles, logger, writeFunc, d.Attr.ChangesOnly, d.Attr.S3ContentType)
+	if len(capture.truncated) > 0 {
+		d.saveRollback(logger, capture) // keep previous file
+		return &truncatedError{commands: capture.truncated}
+	}
+
+	path, writeErr := capture.out.Commit(maxFiles, logger, d.Attr.ChangesOnly, guard)
+	capture.out = nil
 	if writeErr != nil {
-		return fmt.Errorf("saveCommit: error: %v", writeErr)
+		return fmt.Errorf("saveCommit: error: %w", writeErr)
 	}
 
 	logger.Printf("saveCommit: dev '%s' saved to '%s'", d.ID, path)
@@ -360,16 +562,37 @@ func (d *Device) saveCommit(logger hasPrintf, capture *dialog, repository string
 	return nil
 }
 
+// shrinkGuard builds the shrink guard from device attributes, falling back to global options.
+func (d *Device) shrinkGuard(opt *conf.AppConfig) *store.ShrinkGuard {
+	pick := func(devValue, global int) int {
+		switch {
+		case devValue < 0:
+			return 0 // disabled for device
+		case devValue > 0:
+			return devValue
+		}
+		return global
+	}
+	g := &store.ShrinkGuard{
+		Percent: pick(d.Attr.ShrinkPercent, opt.ShrinkPercent),
+		Lines:   pick(d.Attr.ShrinkLines, opt.ShrinkLines),
+	}
+	if g.Percent < 1 && g.Lines < 1 {
+		return nil
+	}
+	return g
+}
+
 type hasTimeout interface {
 	Timeout() bool
 }
 
-func (d *Device) match(logger hasPrintf, t transp, capture *dialog, patterns []string) (int, []byte, error) {
+func (d *Device) match(ctx context.Context, logger hasPrintf, t transp, capture *dialog, patterns []string) (int, []byte, error) {
 
 	d.debugf("match: begin")
 
 	const badIndex = -1
-	var matchBuf []byte
+	matchBuf := capture.resume()
 
 	var expList []*regexp.Regexp
 
@@ -388,6 +611,16 @@ func (d *Device) match(logger hasPrintf, t transp, capture *dialog, patterns []s
 		}
 	}
 
+	var pagerExp *regexp.Regexp
+	if d.Attr.PagerPromptPattern != "" {
+		exp, badExp := regexp.Compile(d.Attr.PagerPromptPattern)
+		if badExp != nil {
+			return badIndex, matchBuf, fmt.Errorf("match: bad pager pattern '%s': %v", d.Attr.PagerPromptPattern, badExp)
+		}
+		pagerExp = exp
+	}
+	pagerErase := 0 // backspaces expected from device erasing scrubbed pager prompt
+
 	begin := time.Now()
 	buf := make([]byte, 100000)
 
@@ -405,6 +638,11 @@ READ_LOOP:
 			return badIndex, matchBuf, fmt.Errorf("match: could not set read timeout: %v", err)
 		}
 
+		// checked after SetDeadline: a later cancel expires the deadline set above
+		if err := ctx.Err(); err != nil {
+			return badIndex, matchBuf, fmt.Errorf("match: %w", err)
+		}
+
 		eof := false
 
 		d.debugf("match: reading")
@@ -414,6 +652,9 @@ READ_LOOP:
 		d.debugf("match: read: %d bytes", n)
 
 		if readErr != nil {
+			if err := ctx.Err(); err != nil {
+				return badIndex, matchBuf, fmt.Errorf("match: %w", err) // read interrupted by cancel
+			}
 			if te, ok := readErr.(hasTimeout); ok {
 				if te.Timeout() {
 					return badIndex, matchBuf, fmt.Errorf("match: read timed out: %v", readErr)
@@ -439,6 +680,7 @@ READ_LOOP:
 		d.debugf("recv1(%d): [%q]", len(lastRead), lastRead)
 
 		if !d.Attr.KeepControlChars {
+			lastRead, pagerErase = skipPagerErase(lastRead, pagerErase)
 			matchBuf, lastRead = removeControlChars(d, d.Debug, matchBuf, lastRead)
 		}
 
@@ -446,6 +688,18 @@ READ_LOOP:
 
 		matchBuf = append(matchBuf, lastRead...)
 
+		if pagerExp != nil && !eof {
+			var scrubbed int
+			if matchBuf, scrubbed = scrubPagerPrompt(pagerExp, matchBuf); scrubbed > 0 {
+				d.debugf("match: pager prompt found, sending [%q]", d.pagerResponse())
+				if err := d.send(logger, t, d.pagerResponse()); err != nil {
+					return badIndex, matchBuf, fmt.Errorf("match: could not send pager response: %v", err)
+				}
+				pagerErase = scrubbed
+				continue READ_LOOP // device is waiting for us
+			}
+		}
+
 		if expList != nil {
 			var sep []byte
 			if bytes.IndexByte(lastRead, CR) >= 0 {
@@ -470,9 +724,50 @@ READ_LOOP:
 			return badIndex, matchBuf, io.EOF
 		}
 
-		lineCount := bytes.Count(matchBuf, []byte{'\n'})
-		d.debugf("match: FIXME limit input size: total size=%d lines=%d", len(matchBuf), lineCount)
+		if limit := d.Attr.MaxCaptureSize; limit > 0 && capture.size+int64(len(matchBuf)) > limit {
+			capture.overflow = true
+			return badIndex, matchBuf, fmt.Errorf("match: capture size exceeded max=%d", limit)
+		}
+
+		// keep only the incomplete last line, still subject to pager scrub and control chars
+		var saveErr error
+		if matchBuf, saveErr = d.saveLines(capture, matchBuf); saveErr != nil {
+			return badIndex, matchBuf, fmt.Errorf("match: %v", saveErr)
+		}
+
+		d.debugf("match: total size=%d", capture.size+int64(len(matchBuf)))
+	}
+}
+
+func (d *Device) pagerResponse() string {
+	if d.Attr.PagerResponse == "" {
+		return " "
 	}
+	return d.Attr.PagerResponse
+}
+
+// scrubPagerPrompt removes the pager prompt from the last line of buf.
+// It returns the size of text removed, zero if the pager prompt is absent.
+func scrubPagerPrompt(pagerExp *regexp.Regexp, buf []byte) ([]byte, int) {
+	lineStart := bytes.LastIndexByte(buf, LF) + 1
+	loc := pagerExp.FindIndex(buf[lineStart:])
+	if loc == nil || loc[0] == loc[1] {
+		return buf, 0
+	}
+	begin, end := lineStart+loc[0], lineStart+loc[1]
+	return append(buf[:begin], buf[end:]...), end - begin
+}
+
+// skipPagerErase drops up to erase leading backspaces sent by device to erase the pager prompt already scrubbed from output.
+func skipPagerErase(buf []byte, erase int) ([]byte, int) {
+	i := 0
+	for i < len(buf) && i < erase && buf[i] == BS {
+		i++
+	}
+	if len(buf) == 0 {
+		return buf, erase // keep waiting
+	}
+	return buf[i:], 0
 }
 
 // Some constants.
@@ -517,7 +812,7 @@ func (d *Device) sendBytes(logger hasPrintf, t transp, msg []byte) error {
 	return wrErr
 }
 
-func (d *Device) matchCommandPrompt(t transp, capture *dialog) (matchBuf []byte, enabledPrompt, wantEOF bool, errMatch error) {
+func (d *Device) matchCommandPrompt(ctx context.Context, t transp, capture *dialog) (matchBuf []byte, enabledPrompt, wantEOF bool, errMatch error) {
 
 	wantEOF = d.Attr.DisabledPromptPattern == ""
 
@@ -527,7 +822,7 @@ func (d *Device) matchCommandPrompt(t transp, capture *dialog) (matchBuf []byte,
 		list = append(list, d.Attr.EnabledPromptPattern)
 	}
 
-	m, buf, err := d.match(d.logger, t, capture, list)
+	m, buf, err := d.match(ctx, d.logger, t, capture, list)
 
 	enabledPrompt = m == 1
 	matchBuf = buf
@@ -543,7 +838,12 @@ func (d *Device) matchCommandPrompt(t transp, capture *dialog) (matchBuf []byte,
 	return
 }
 
-func (d *Device) sendCommands(logger hasPrintf, t transp, capture *dialog) error {
+func (d *Device) sendCommands(ctx context.Context, logger hasPrintf, t transp, capture *dialog) error {
+
+	errPatterns, patternErr := d.errorPatterns()
+	if patternErr != nil {
+		return fmt.Errorf("sendCommands: %v", patternErr)
+	}
 
 	// save timeouts
 	saveReadTimeout := d.Attr.ReadTimeout
@@ -569,9 +869,13 @@ func (d *Device) sendCommands(logger hasPrintf, t transp, capture *dialog) error
 			}
 		}
 
+		if err := d.saveBegin(capture, c, errPatterns); err != nil {
+			return fmt.Errorf("sendCommands: could not save command '%s' result: %v", c, err)
+		}
+
 		d.debugf("waiting response for command=[%s]", c)
 
-		matchBuf, _, wantEOF, matchErr := d.matchCommandPrompt(t, capture)
+		matchBuf, wantEOF, matchErr := d.matchCommandChat(ctx, logger, t, capture, c)
 
 		switch matchErr {
 		case nil: // ok
@@ -584,9 +888,13 @@ func (d *Device) sendCommands(logger hasPrintf, t transp, capture *dialog) error
 			return fmt.Errorf("sendCommands: could not match command prompt: %v buf=[%s]", matchErr, matchBuf)
 		}
 
+		if line, found := capture.cmd.findError(matchBuf); found {
+			return &commandError{command: c, line: string(line)}
+		}
+
 		d.debugf("saving response for command=[%s]", c)
 
-		if saveErr := d.save(logger, capture, c, matchBuf); saveErr != nil {
+		if saveErr := d.saveEnd(capture, matchBuf); saveErr != nil {
 			return fmt.Errorf("sendCommands: could not save command '%s' result: %v", c, saveErr)
 		}
 	}
@@ -594,21 +902,148 @@ func (d *Device) sendCommands(logger hasPrintf, t transp, capture *dialog) error
 	return nil
 }
 
+// save writes a whole command output into the tmp file.
 func (d *Device) save(logger hasPrintf, capture *dialog, command string, buf []byte) error {
+	if err := d.saveBegin(capture, command, nil); err != nil {
+		return err
+	}
+	return d.saveEnd(capture, buf)
+}
+
+// commandOutput tracks a command output streamed into the tmp file line by line.
+// Only the incomplete last line is held in memory.
+type commandOutput struct {
+	command  string
+	errors   *errorPatterns // nil means no error check
+	errLine  []byte         // first saved line matching an error pattern
+	errFound bool
+	endExp   *regexp.Regexp // nil means no end marker
+	endFound bool
+	tail     []byte // output left unsaved by a chat step
+}
 
+// scan looks for errors and end marker on complete output lines.
+func (o *commandOutput) scan(lines []byte) {
+	if o.errors != nil && !o.errFound {
+		if line, found := o.errors.find(lines); found {
+			o.errLine = append([]byte(nil), line...) // lines buffer is reused
+			o.errFound = true
+		}
+	}
+	if o.endExp != nil && !o.endFound {
+		for _, line := range bytes.Split(lines, []byte{LF}) {
+			if o.endExp.Match(bytes.TrimRight(line, "\r")) {
+				o.endFound = true
+				break
+			}
+		}
+	}
+}
+
+// findError returns the first output line matching an error pattern, either already saved or in the unsaved tail.
+func (o *commandOutput) findError(tail []byte) ([]byte, bool) {
+	if o.errFound {
+		return o.errLine, true
+	}
+	if o.errors == nil {
+		return nil, false
+	}
+	return o.errors.find(tail)
+}
+
+// resume takes the command output left unsaved by a previous match, as the incomplete question line of a chat step.
+func (capture *dialog) resume() []byte {
+	if capture.cmd == nil {
+		return nil
+	}
+	buf := capture.cmd.tail
+	capture.cmd.tail = nil
+	return buf
+}
+
+// saveBegin writes the command header into the tmp file, then the command output is streamed by saveLines.
+func (d *Device) saveBegin(capture *dialog, command string, errPatterns *errorPatterns) error {
+
+	if capture.out == nil {
+		return fmt.Errorf("saveBegin: tmp file not open")
+	}
+
+	endExp, endErr := d.endMarkerExp(command)
+	if endErr != nil {
+		return fmt.Errorf("saveBegin: %v", endErr)
+	}
+
+	var header string
 	if command != "" {
-		command = fmt.Sprintf("%q", command)
+		header = fmt.Sprintf("%q", command)
 		if d.Attr.QuoteSentCommandsFormat != "" {
-			command = fmt.Sprintf(d.Attr.QuoteSentCommandsFormat, command)
+			header = fmt.Sprintf(d.Attr.QuoteSentCommandsFormat, header)
 		}
-		command = "\n" + command + "\n"
+		header = "\n" + header + "\n"
+	}
+
+	if err := d.saveBlock(capture, []byte(header)); err != nil {
+		return fmt.Errorf("saveBegin: %v", err)
+	}
+
+	capture.cmd = &commandOutput{command: command, errors: errPatterns, endExp: endExp}
+
+	return nil
+}
+
+// saveLines writes the complete lines from buf into the tmp file.
+// It returns the incomplete last line, reusing buf.
+func (d *Device) saveLines(capture *dialog, buf []byte) ([]byte, error) {
+
+	if capture.cmd == nil {
+		return buf, nil // not saving: login, enable, pager off
+	}
+
+	end := bytes.LastIndexByte(buf, LF) + 1
+	if end == 0 {
+		return buf, nil // no complete line
+	}
+
+	lines := buf[:end-1] // the line filter splits on LF then restores it
+	if capture.filter == nil {
+		lines = buf[:end]
+	}
+
+	capture.cmd.scan(buf[:end-1])
+
+	if err := d.saveBlock(capture, lines); err != nil {
+		return buf, fmt.Errorf("saveLines: %v", err)
+	}
+
+	capture.size += int64(end)
+
+	return buf[:copy(buf, buf[end:])], nil
+}
+
+// saveEnd writes the tail of the command output into the tmp file and checks the end marker.
+func (d *Device) saveEnd(capture *dialog, buf []byte) error {
+
+	o := capture.cmd
+	capture.cmd = nil
+
+	if limit := d.Attr.MaxCaptureSize; limit > 0 && capture.size+int64(len(buf)) > limit {
+		capture.overflow = true
+		return fmt.Errorf("saveEnd: capture size exceeded max=%d", limit)
+	}
+
+	o.scan(buf)
+	d.checkEndMarker(capture, o)
+
+	if err := d.saveBlock(capture, buf); err != nil {
+		return fmt.Errorf("saveEnd: %v", err)
 	}
 
-	capture.save = append(capture.save, []byte(command), buf)
+	capture.size += int64(len(buf))
+
 	return nil
 }
 
-func (d *Device) pagingOff(logger hasPrintf, t transp, capture *dialog) error {
+func (d *Device) pagingOff(ctx context.Context, logger hasPrintf, t transp, capture *dialog) error {
 
 	if pagerErr := d.sendln(logger, t, d.Attr.DisablePagerCommand); pagerErr != nil {
 		return fmt.Errorf("pager off: could not send pager disabling command '%s': %v", d.Attr.DisablePagerCommand, pagerErr)
@@ -622,7 +1057,7 @@ func (d *Device) pagingOff(logger hasPrintf, t transp, capture *dialog) error {
 
 		var buf []byte
 		var err error
-		if buf, _, _, err = d.matchCommandPrompt(t, capture); err != nil {
+		if buf, _, _, err = d.matchCommandPrompt(ctx, t, capture); err != nil {
 			return fmt.Errorf("pagingOff: %d/%d could not match command prompt: %v", i, matchCount, err)
 		}
 
@@ -632,7 +1067,7 @@ func (d *Device) pagingOff(logger hasPrintf, t transp, capture *dialog) error {
 	return nil
 }
 
-func (d *Device) enable(logger hasPrintf, t transp, capture *dialog) error {
+func (d *Device) enable(ctx context.Context, logger hasPrintf, t transp, capture *dialog) error {
 
 	// test enabled prompt
 
@@ -644,7 +1079,7 @@ func (d *Device) enable(logger hasPrintf, t transp, capture *dialog) error {
 
 	d.debugf("enable: expecting prompt")
 
-	_, enabled, _, err0 := d.matchCommandPrompt(t, capture)
+	_, enabled, _, err0 := d.matchCommandPrompt(ctx, t, capture)
 	if err0 != nil {
 		return fmt.Errorf("enable: could not find command prompt: %v", err0)
 	}
@@ -672,7 +1107,7 @@ func (d *Device) enable(logger hasPrintf, t transp, capture *dialog) error {
 
 		d.debugf("enable: expecting enabled prompt - no pattern for enable password prompt")
 
-		_, _, err := d.match(logger, t, capture, []string{d.Attr.EnabledPromptPattern})
+		_, _, err := d.match(ctx, logger, t, capture, []string{d.Attr.EnabledPromptPattern})
 		if err != nil {
 			return fmt.Errorf("enable: could not match after-enable prompt: %v", err)
 		}
@@ -681,7 +1116,7 @@ func (d *Device) enable(logger hasPrintf, t transp, capture *dialog) error {
 
 	}
 
-	m, _, err := d.match(logger, t, capture, []string{d.Attr.EnablePasswordPromptPattern, d.Attr.EnabledPromptPattern})
+	m, _, err := d.match(ctx, logger, t, capture, []string{d.Attr.EnablePasswordPromptPattern, d.Attr.EnabledPromptPattern})
 	if err != nil {
 		return fmt.Errorf("enable: could not match after-enable prompt: %v", err)
 	}
@@ -694,16 +1129,16 @@ func (d *Device) enable(logger hasPrintf, t transp, capture *dialog) error {
 		return fmt.Errorf("enable: could not send enable password: %v", passErr)
 	}
 
-	if _, _, mismatch := d.match(logger, t, capture, []string{d.Attr.EnabledPromptPattern}); mismatch != nil {
+	if _, _, mismatch := d.match(ctx, logger, t, capture, []string{d.Attr.EnabledPromptPattern}); mismatch != nil {
 		return fmt.Errorf("enable: could not find enabled command prompt: %v", mismatch)
 	}
 
 	return nil
 }
 
-func (d *Device) login(logger hasPrintf, t transp, capture *dialog) (bool, error) {
+func (d *Device) login(ctx context.Context, logger hasPrintf, t transp, capture *dialog) (bool, error) {
 
-	m1, _, err := d.match(logger, t, capture, []string{d.Attr.UsernamePromptPattern, d.Attr.PasswordPromptPattern})
+	m1, _, err := d.match(ctx, logger, t, capture, []string{d.Attr.UsernamePromptPattern, d.Attr.PasswordPromptPattern})
 	if err != nil {
 		return false, fmt.Errorf("login: could not find username prompt: %v", err)
 	}
@@ -743,7 +1178,7 @@ func (d *Device) login(logger hasPrintf, t transp, capture *dialog) (bool, error
 			return false, fmt.Errorf("login: find password prompt: no pattern provided")
 		}
 
-		m2, _, err := d.match(logger, t, capture, list)
+		m2, _, err := d.match(ctx, logger, t, capture, list)
 		if err != nil {
 			return false, fmt.Errorf("login: could not find password prompt: %v", err)
 		}
@@ -799,7 +1234,7 @@ func (d *Device) login(logger hasPrintf, t transp, capture *dialog) (bool, error
 
 		var m int
 		var mismatch error
-		m, _, mismatch = d.match(logger, t, capture, list)
+		m, _, mismatch = d.match(ctx, logger, t, capture, list)
 		if mismatch != nil {
 			return false, fmt.Errorf("post-login-prompt: match: %v", mismatch)
 		}
@@ -818,7 +1253,7 @@ func (d *Device) login(logger hasPrintf, t transp, capture *dialog) (bool, error
 		}
 	}
 
-	_, enabled, _, err := d.matchCommandPrompt(t, capture)
+	_, enabled, _, err := d.matchCommandPrompt(ctx, t, capture)
 	if err != nil {
 		return false, fmt.Errorf("login: could not find command prompt: %v", err)
 	}
@@ -854,11 +1289,42 @@ func ClearDeviceStatus(tab DeviceUpdater, devID string, logger hasPrintf, holdti
 	return d, nil
 }
 
-// UpdateLastSuccess loads device last success from filesystem.
+// SetDeviceQuarantine records whether a suspect backup is held by the shrink guard for a device.
+// The state is kept on the device so the device list does not look up the quarantine file.
+func SetDeviceQuarantine(tab DeviceUpdater, devID string, quarantined bool, logger hasPrintf) {
+	d, getErr := tab.GetDevice(devID)
+	if getErr != nil {
+		logger.Printf("SetDeviceQuarantine: '%s' not found: %v", devID, getErr)
+		return
+	}
+	d.quarantined = quarantined
+	tab.UpdateDevice(d)
+}
+
+// RecoverRepository cleans up device directories after a crash.
+// It removes stale tmp files and rebuilds missing or inconsistent shortcut files.
+func RecoverRepository(tab *DeviceTable, logger hasPrintf, repository string) {
+	for _, d := range tab.ListDevices() {
+		prefix := d.DevicePathPrefix(d.DeviceDir(repository))
+
+		store.CleanStaleTmp(prefix, store.StaleTmpAge, logger)
+
+		if _, repairErr := store.RepairShortcut(prefix, logger); repairErr != nil {
+			logger.Printf("RecoverRepository: '%s': %v", prefix, repairErr)
+		}
+	}
+}
+
+// UpdateLastSuccess loads device last success and quarantine state from filesystem.
 func UpdateLastSuccess(tab *DeviceTable, logger hasPrintf, repository string) {
 	for _, d := range tab.ListDevices() {
 		prefix := d.DevicePathPrefix(d.DeviceDir(repository))
 
+		if store.FileExists(store.QuarantinePath(prefix)) {
+			d.quarantined = true
+			tab.UpdateDevice(d)
+		}
+
 		lastConfig, lastErr := store.FindLastConfig(prefix, logger)
 		if lastErr != nil {
 			logger.Printf("UpdateLastSuccess: find last: '%s': %v", prefix, lastErr)
@@ -26,6 +26,8 @@ func registerModelCiscoIOS(logger hasPrintf, t *DeviceTable) {
 	a.CommandReadTimeout = 20 * time.Second  // larger timeout for slow 'sh run'
 	a.CommandMatchTimeout = 30 * time.Second // larger timeout for slow 'sh run'
 	a.QuoteSentCommandsFormat = `!![%s]`
+	a.ErrorPatterns = []string{`^% ?(Invalid input detected|Incomplete command|Ambiguous command)`}
+	a.EndMarkers = []conf.EndMarker{{Command: "show run", Pattern: `^end\s*$`}}
 
 	m := &Model{name: "cisco-ios"}
 	m.defaultAttr = a
@@ -25,6 +25,8 @@ func registerModelCiscoIOSXR(logger hasPrintf, t *DeviceTable) {
 	a.CommandReadTimeout = 20 * time.Second  // larger timeout for slow 'sh run'
 	a.CommandMatchTimeout = 30 * time.Second // larger timeout for slow 'sh run'
 	a.QuoteSentCommandsFormat = `!![%s]`
+	a.ErrorPatterns = []string{`^% ?(Invalid input detected|Incomplete command|Ambiguous command)`}
+	a.EndMarkers = []conf.EndMarker{{Command: "show run", Pattern: `^end\s*$`}}
 	a.LineFilter = "iosxr" // line filter name - applied to every saved line
 
 	m := &Model{name: "cisco-iosxr"}
@@ -1,6 +1,7 @@
 package dev
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -42,8 +43,8 @@ func TestCiscoIOSXR1(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 1 || bad != 0 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
@@ -78,8 +79,8 @@ func TestCiscoIOSXR2(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 1 || bad != 0 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
@@ -113,8 +114,8 @@ func TestCiscoIOSXR3(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 0 || bad != 1 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
@@ -224,7 +225,7 @@ LOOP:
 				return
 			}
 
-			if _, err := c.Write([]byte("\nshow running-configuration\nthis is the IOS XR config\n")); err != nil {
+			if _, err := c.Write([]byte("\nshow running-configuration\nthis is the IOS XR config\nend\n")); err != nil {
 				t.Logf("handleConnectionCiscoIOSXR: send sh run error: %v", err)
 				return
 			}
@@ -1,6 +1,7 @@
 package dev
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -60,8 +61,8 @@ func TestCiscoIOS1(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 1 || bad != 0 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
@@ -96,8 +97,8 @@ func TestCiscoIOS2(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 1 || bad != 0 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
@@ -131,8 +132,8 @@ func TestCiscoIOS3(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 0 || bad != 1 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
@@ -171,8 +172,8 @@ func TestCiscoIOS4(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 1000 || bad != 0 || skip != 0 {
 		t.Errorf("good=%d bad=%d", good, bad)
 	}
@@ -282,10 +283,56 @@ LOOP:
 				return
 			}
 
-			if _, err := c.Write([]byte("\nshow running-configuration")); err != nil {
+			if _, err := c.Write([]byte("\nshow running-configuration\nend")); err != nil {
 				t.Logf("handleConnectionCiscoIOS: send sh run error: %v", err)
 				return
 			}
+		case strings.HasPrefix(str, "copy"): //copy run start - interactive questions
+			for _, q := range []string{"\nDestination filename [startup-config]? ", "\nDo you want to continue (y/n)? "} {
+				if _, err := c.Write([]byte(q)); err != nil {
+					t.Logf("handleConnectionCiscoIOS: send copy question error: %v", err)
+					return
+				}
+				n, err := c.Read(buf)
+				if err != nil {
+					t.Logf("handleConnectionCiscoIOS: read copy answer error: %v", err)
+					return
+				}
+				if _, err := c.Write([]byte(fmt.Sprintf("\nanswer=%q", buf[:n]))); err != nil {
+					t.Logf("handleConnectionCiscoIOS: send copy answer error: %v", err)
+					return
+				}
+			}
+		case strings.HasPrefix(str, "more"): //more - pager ignoring term len 0
+			for i := 1; i <= 3; i++ {
+				if _, err := c.Write([]byte(fmt.Sprintf("\npage%d\n --More-- ", i))); err != nil {
+					t.Logf("handleConnectionCiscoIOS: send more page error: %v", err)
+					return
+				}
+				n, err := c.Read(buf)
+				if err != nil {
+					t.Logf("handleConnectionCiscoIOS: read more key error: %v", err)
+					return
+				}
+				if string(buf[:n]) != " " {
+					t.Logf("handleConnectionCiscoIOS: more: unexpected key: %q", buf[:n])
+					return
+				}
+				erase := strings.Repeat("\b", 10)
+				if _, err := c.Write([]byte(erase + strings.Repeat(" ", 10) + erase)); err != nil {
+					t.Logf("handleConnectionCiscoIOS: send more erase error: %v", err)
+					return
+				}
+			}
+			if _, err := c.Write([]byte("\nlast page")); err != nil {
+				t.Logf("handleConnectionCiscoIOS: send more last page error: %v", err)
+				return
+			}
+		case strings.HasPrefix(str, "bogus"): //bogus - invalid command
+			if _, err := c.Write([]byte("\n       ^\n% Invalid input detected at '^' marker.\n")); err != nil {
+				t.Logf("handleConnectionCiscoIOS: send invalid input error: %v", err)
+				return
+			}
 		case strings.HasPrefix(str, "en"): //enable
 			if !enabled {
 				// send password prompt
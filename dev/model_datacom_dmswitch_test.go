@@ -1,6 +1,7 @@
 package dev
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -40,8 +41,8 @@ func TestDatacomDmswitch1(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 1 || bad != 0 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
@@ -76,8 +77,8 @@ func TestDatacomDmswitch2(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 0 || bad != 1 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
@@ -112,8 +113,8 @@ func TestDatacomDmswitch3(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 0 || bad != 1 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
@@ -0,0 +1,167 @@
+package dev
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+const modelFileMaxSize = 1000000 // 1M limit for model file
+
+const modelSourceConfig = "config" // source for models from the config Models section
+
+// modelDecl is a declarative model definition and where it came from.
+type modelDecl struct {
+	def    conf.ModelConfig
+	source string
+}
+
+// LoadModels replaces the declarative models in the device table.
+// Definitions are read from every *.yaml or *.yml file in modelsDir, then from the config Models section.
+// Each model starts from the attributes of the model it extends and overrides only the attributes it declares.
+// Broken models are reported in the returned error; the other ones are loaded anyway.
+func LoadModels(logger hasPrintf, t *DeviceTable, modelsDir string, defs []conf.ModelConfig) error {
+	var decls []modelDecl
+	var errList []string
+
+	files, listErr := modelFiles(modelsDir)
+	if listErr != nil {
+		errList = append(errList, listErr.Error())
+	}
+	for _, path := range files {
+		list, loadErr := conf.LoadModels(path, modelFileMaxSize)
+		if loadErr != nil {
+			errList = append(errList, fmt.Sprintf("%s: %v", path, loadErr))
+			continue
+		}
+		for _, def := range list {
+			decls = append(decls, modelDecl{def: def, source: path})
+		}
+	}
+	for _, def := range defs {
+		decls = append(decls, modelDecl{def: def, source: modelSourceConfig})
+	}
+
+	byName := map[string]modelDecl{}
+	var names []string
+	for _, decl := range decls {
+		name := decl.def.Name
+		if name == "" {
+			errList = append(errList, fmt.Sprintf("%s: model with empty name", decl.source))
+			continue
+		}
+		if prev, found := byName[name]; found {
+			errList = append(errList, fmt.Sprintf("%s: model '%s' already defined in %s", decl.source, name, prev.source))
+			continue
+		}
+		byName[name] = decl
+		names = append(names, name)
+	}
+
+	var models []*Model
+	for _, name := range names {
+		a, attrErr := resolveModelAttr(t, byName, name, map[string]bool{})
+		if attrErr != nil {
+			errList = append(errList, fmt.Sprintf("%s: %v", byName[name].source, attrErr))
+			continue
+		}
+		models = append(models, &Model{name: name, defaultAttr: a, source: byName[name].source})
+	}
+
+	if replaceErr := t.ReplaceModels(models, defs, logger); replaceErr != nil {
+		errList = append(errList, replaceErr.Error())
+	}
+
+	if errList != nil {
+		return fmt.Errorf("LoadModels: %s", strings.Join(errList, "; "))
+	}
+	return nil
+}
+
+// modelFiles lists model files in dir. Missing dir means no model files.
+func modelFiles(dir string) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("models dir: %v", err)
+	}
+	var files []string
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// resolveModelAttr builds the attributes for a declarative model, following the Extends chain.
+func resolveModelAttr(t *DeviceTable, byName map[string]modelDecl, name string, visiting map[string]bool) (conf.DevAttributes, error) {
+	if visiting[name] {
+		return conf.DevAttributes{}, fmt.Errorf("model '%s': extends loop", name)
+	}
+	visiting[name] = true
+
+	def := byName[name].def
+
+	var a conf.DevAttributes
+
+	switch base := def.Extends; {
+	case base == "":
+		a = conf.NewDevAttr()
+	case byName[base].def.Name != "":
+		baseAttr, baseErr := resolveModelAttr(t, byName, base, visiting)
+		if baseErr != nil {
+			return a, baseErr
+		}
+		a = baseAttr
+	default:
+		m, getErr := t.GetModel(base)
+		if getErr != nil || m.source != "" {
+			return a, fmt.Errorf("model '%s': unknown base model '%s'", name, base)
+		}
+		a = m.defaultAttr
+	}
+
+	if def.Attr.Kind != 0 && def.Attr.Tag != "!!null" {
+		if err := def.Attr.Decode(&a); err != nil {
+			return a, fmt.Errorf("model '%s': attr: %v", name, err)
+		}
+	}
+
+	return a, nil
+}
+
+// refreshModelAttr applies a reloaded model to device attributes.
+// Each attribute still holding the old model value takes the new model value; attributes changed on the device are kept.
+func refreshModelAttr(oldModel, newModel, device conf.DevAttributes) conf.DevAttributes {
+	o := reflect.ValueOf(oldModel)
+	n := reflect.ValueOf(newModel)
+	d := reflect.ValueOf(&device).Elem()
+	for i := 0; i < d.NumField(); i++ {
+		f := d.Field(i)
+		if f.CanSet() && sameAttrValue(f, o.Field(i)) {
+			f.Set(n.Field(i))
+		}
+	}
+	return device
+}
+
+// sameAttrValue compares attribute values, taking nil and empty lists as equal since config reload turns one into the other.
+func sameAttrValue(a, b reflect.Value) bool {
+	if k := a.Kind(); (k == reflect.Slice || k == reflect.Map) && a.Len() == 0 && b.Len() == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
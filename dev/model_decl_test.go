@@ -0,0 +1,169 @@
+package dev
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+const testModelFile = `
+- name: ios-brief
+  extends: cisco-ios
+  attr:
+    commandlist: [show run brief]
+    commandreadtimeout: 45s
+- name: ios-brief-sw
+  extends: ios-brief
+  attr:
+    disablepagercommand: terminal length 0
+- name: plain
+  attr:
+    runprog: [/bin/true]
+`
+
+func TestLoadModels(t *testing.T) {
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ios.yaml"), []byte(testModelFile), 0640); err != nil {
+		t.Fatalf("write model file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("garbage"), 0640); err != nil {
+		t.Fatalf("write model file: %v", err)
+	}
+
+	cfg, parseErr := conf.NewConfigFromString(`
+models:
+- name: from-config
+  extends: ios-brief-sw
+  attr:
+    enablecommand: en
+`)
+	if parseErr != nil {
+		t.Fatalf("parse config: %v", parseErr)
+	}
+
+	if err := LoadModels(logger, tab, dir, cfg.Models); err != nil {
+		t.Fatalf("LoadModels: %v", err)
+	}
+
+	ios, _ := tab.GetModel("cisco-ios")
+
+	m, getErr := tab.GetModel("from-config")
+	if getErr != nil {
+		t.Fatalf("model from-config: %v", getErr)
+	}
+	a := m.defaultAttr
+	if !reflect.DeepEqual(a.CommandList, []string{"show run brief"}) {
+		t.Errorf("commandlist: %q", a.CommandList)
+	}
+	if a.CommandReadTimeout != 45*time.Second || a.DisablePagerCommand != "terminal length 0" || a.EnableCommand != "en" {
+		t.Errorf("overrides: readtimeout=%v pager=%q enable=%q", a.CommandReadTimeout, a.DisablePagerCommand, a.EnableCommand)
+	}
+	if a.EnabledPromptPattern != ios.defaultAttr.EnabledPromptPattern || a.CommandMatchTimeout != ios.defaultAttr.CommandMatchTimeout {
+		t.Errorf("inherited: prompt=%q matchtimeout=%v", a.EnabledPromptPattern, a.CommandMatchTimeout)
+	}
+	if !reflect.DeepEqual(ios.defaultAttr.CommandList, []string{"show ver", "show run"}) {
+		t.Errorf("base model changed: %q", ios.defaultAttr.CommandList)
+	}
+
+	if p, _ := tab.GetModel("plain"); p.defaultAttr.ErrlogHistSize != conf.NewDevAttr().ErrlogHistSize {
+		t.Errorf("plain model: missing defaults: %+v", p.defaultAttr)
+	}
+
+	if src := tab.ModelSource("ios-brief"); src != filepath.Join(dir, "ios.yaml") {
+		t.Errorf("source: %q", src)
+	}
+	if src := tab.ModelSource("from-config"); src != "config" {
+		t.Errorf("source: %q", src)
+	}
+	if src := tab.ModelSource("cisco-ios"); src != "built-in" {
+		t.Errorf("source: %q", src)
+	}
+
+	if err := CreateDevice(tab, logger, "ios-brief", "lab1", "localhost", "telnet", "lab", "pass", "en", false, nil); err != nil {
+		t.Errorf("create device: %v", err)
+	}
+
+	// reload changed model: device gets new model values, keeps its own overrides
+
+	d, _ := tab.GetDevice("lab1")
+	d.Attr.CommandList = []string{"show run all"}
+	tab.UpdateDevice(d)
+
+	changed := strings.Replace(testModelFile, "commandreadtimeout: 45s", "commandreadtimeout: 60s\n    commandlist: [show run]", 1)
+	changed = strings.Replace(changed, "    commandlist: [show run brief]\n", "", 1)
+	if err := os.WriteFile(filepath.Join(dir, "ios.yaml"), []byte(changed), 0640); err != nil {
+		t.Fatalf("write model file: %v", err)
+	}
+	if err := LoadModels(logger, tab, dir, tab.ModelDefs()); err != nil {
+		t.Fatalf("reload changed: %v", err)
+	}
+	d, _ = tab.GetDevice("lab1")
+	if d.Attr.CommandReadTimeout != 60*time.Second || d.devModel.defaultAttr.CommandReadTimeout != 60*time.Second {
+		t.Errorf("reload changed: model attribute not refreshed: readtimeout=%v", d.Attr.CommandReadTimeout)
+	}
+	if !reflect.DeepEqual(d.Attr.CommandList, []string{"show run all"}) {
+		t.Errorf("reload changed: device override lost: commandlist=%q", d.Attr.CommandList)
+	}
+
+	// reload: drop model file, keep config models
+
+	if err := os.Remove(filepath.Join(dir, "ios.yaml")); err != nil {
+		t.Fatalf("remove model file: %v", err)
+	}
+	reloadErr := LoadModels(logger, tab, dir, tab.ModelDefs())
+	if reloadErr == nil || !strings.Contains(reloadErr.Error(), "unknown base model 'ios-brief-sw'") {
+		t.Errorf("reload: expected missing base error, got: %v", reloadErr)
+	}
+	if _, err := tab.GetModel("ios-brief"); err == nil {
+		t.Errorf("reload: removed model still registered")
+	}
+	if _, err := tab.GetModel("cisco-ios"); err != nil {
+		t.Errorf("reload: built-in model removed: %v", err)
+	}
+}
+
+func TestLoadModelsErrors(t *testing.T) {
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+
+	cfg, parseErr := conf.NewConfigFromString(`
+models:
+- name: loop1
+  extends: loop2
+- name: loop2
+  extends: loop1
+- name: cisco-ios
+- name: dup
+- name: dup
+- name: badattr
+  attr:
+    commandlist: not-a-list
+- name: good
+`)
+	if parseErr != nil {
+		t.Fatalf("parse config: %v", parseErr)
+	}
+
+	err := LoadModels(logger, tab, filepath.Join(t.TempDir(), "missing"), cfg.Models)
+	if err == nil {
+		t.Fatalf("expected errors")
+	}
+	for _, want := range []string{"extends loop", "model 'cisco-ios' from config: already exists", "model 'dup' already defined", "model 'badattr': attr"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q: %v", want, err)
+		}
+	}
+	if _, getErr := tab.GetModel("good"); getErr != nil {
+		t.Errorf("good model not loaded: %v", getErr)
+	}
+}
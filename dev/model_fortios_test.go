@@ -1,6 +1,7 @@
 package dev
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -41,8 +42,8 @@ func TestFortiOS1(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 1 || bad != 0 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
@@ -77,8 +78,8 @@ func TestFortiOS2(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 1 || bad != 0 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
@@ -112,8 +113,8 @@ func TestFortiOS3(t *testing.T) {
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
 	if good != 0 || bad != 1 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
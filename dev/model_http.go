@@ -1,6 +1,7 @@
 package dev
 
 import (
+	"regexp"
 	"time"
 
 	"github.com/udhos/jazigo/conf"
@@ -9,13 +10,9 @@ import (
 func registerModelHTTP(logger hasPrintf, t *DeviceTable) {
 	a := conf.NewDevAttr()
 
-	a.CommandList = []string{"GET / HTTP/1.0\r\n\r\n"}
-	a.ReadTimeout = 5 * time.Second
-	a.MatchTimeout = 10 * time.Second
-	a.SendTimeout = 5 * time.Second
-	a.CommandReadTimeout = 5 * time.Second   // larger timeout for slow 'sh run'
-	a.CommandMatchTimeout = 10 * time.Second // larger timeout for slow 'sh run'
-	a.SupressAutoLF = true
+	a.HTTPURLs = []string{"/"}
+	a.HTTPScheme = "http"
+	a.CommandMatchTimeout = 10 * time.Second // full request timeout
 	a.QuoteSentCommandsFormat = `[%s]`
 
 	m := &Model{name: "http"}
@@ -23,4 +20,37 @@ func registerModelHTTP(logger hasPrintf, t *DeviceTable) {
 	if err := t.SetModel(m, logger); err != nil {
 		logger.Printf("registerModelHTTP: %v", err)
 	}
+
+	a.HTTPScheme = "https"
+
+	m = &Model{name: "https"}
+	m.defaultAttr = a
+	if err := t.SetModel(m, logger); err != nil {
+		logger.Printf("registerModelHTTP: %v", err)
+	}
+}
+
+// httpLegacyRequest matches the raw request lines of the former tcp-based http model.
+var httpLegacyRequest = regexp.MustCompile(`^GET (\S+) HTTP/1\.[01]\s*$`)
+
+// httpLegacyMigrate converts http devices saved with raw request lines in CommandList,
+// like "GET / HTTP/1.0\r\n\r\n", into HTTPURLs. Otherwise they would never reach the http fetch.
+func httpLegacyMigrate(logger hasPrintf, c *conf.DevConfig) {
+	if c.Model != "http" || len(c.Attr.HTTPURLs) > 0 || len(c.Attr.CommandList) < 1 {
+		return
+	}
+	var urls []string
+	for _, cmd := range c.Attr.CommandList {
+		m := httpLegacyRequest.FindStringSubmatch(cmd)
+		if m == nil {
+			return // custom request, keep it as is
+		}
+		urls = append(urls, m[1])
+	}
+	c.Attr.HTTPURLs = urls
+	c.Attr.CommandList = nil
+	if c.Attr.HTTPScheme == "" {
+		c.Attr.HTTPScheme = "http"
+	}
+	logger.Printf("httpLegacyMigrate: %s: raw requests converted to httpurls: %v", c.ID, urls)
 }
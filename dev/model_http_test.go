@@ -1,10 +1,16 @@
 package dev
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/udhos/jazigo/conf"
@@ -31,14 +37,26 @@ func TestHTTP1(t *testing.T) {
 	RegisterModels(logger, tab)
 	CreateDevice(tab, logger, "http", "lab1", "localhost"+addr, "", "", "", "", false, nil)
 
+	// device saved by the former raw tcp http model
+	legacy := conf.DevConfig{Model: "http", ID: "lab2", HostPort: "localhost" + addr, Transports: "tcp", Attr: conf.NewDevAttr()}
+	legacy.Attr.CommandList = []string{"GET / HTTP/1.0\r\n\r\n"}
+	d, newErr := NewDeviceFromConf(tab, logger, &legacy)
+	if newErr != nil {
+		t.Fatalf("legacy device: %v", newErr)
+	}
+	if len(d.Attr.HTTPURLs) != 1 || d.Attr.HTTPURLs[0] != "/" || len(d.Attr.CommandList) != 0 {
+		t.Errorf("legacy device not migrated: urls=%q commands=%q", d.Attr.HTTPURLs, d.Attr.CommandList)
+	}
+	tab.SetDevice(d)
+
 	repo := temp.MakeTempRepo()
 	defer temp.CleanupTempRepo()
 
 	requestCh := make(chan FetchRequest)
 	errlogPrefix := filepath.Join(repo, "errlog_test.")
-	go Spawner(tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
-	good, bad, skip := Scan(tab, tab.ListDevices(), logger, opt.Get(), requestCh)
-	if good != 1 || bad != 0 || skip != 0 {
+	go Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+	good, bad, skip := Scan(context.Background(), nil, tab, tab.ListDevices(), logger, opt.Get(), requestCh)
+	if good != 2 || bad != 0 || skip != 0 {
 		t.Errorf("good=%d bad=%d skip=%d", good, bad, skip)
 	}
 
@@ -77,3 +95,143 @@ func spawnServerHTTP(t *testing.T, addr string) (*testServer, error) {
 func rootHandler(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "hello web client\n")
 }
+
+func spawnServerHTTPS(t *testing.T, addr string) (*httptest.Server, error) {
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	m := http.NewServeMux()
+	m.HandleFunc("/config.json", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "lab" || pass != "pass" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"hostname":"fw1","interfaces":[{"name":"port1","ip":"10.0.0.1/24"}]}`)
+	})
+	m.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/config.json", http.StatusFound)
+	})
+	m.HandleFunc("/chunked", func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "chunk %d\n", i)
+			w.(http.Flusher).Flush() // forces chunked transfer encoding
+		}
+	})
+	m.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer pass" || r.Header.Get("X-Api-Key") != "k1" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, "token ok\n")
+	})
+
+	s := httptest.NewUnstartedServer(m)
+	s.Listener.Close()
+	s.Listener = ln
+	s.StartTLS()
+
+	return s, nil
+}
+
+func TestHTTPS(t *testing.T) {
+
+	// launch bogus test server
+	addr := ":2041"
+	s, listenErr := spawnServerHTTPS(t, addr)
+	if listenErr != nil {
+		t.Fatalf("could not spawn bogus HTTPS server: %v", listenErr)
+	}
+	defer s.Close()
+
+	sum := sha256.Sum256(s.Certificate().Raw)
+	pin := hex.EncodeToString(sum[:])
+
+	// run client test
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "https", "lab1", "localhost"+addr, "", "lab", "pass", "", false, nil)
+	appConfig := &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10, MaxConfigLoadSize: 10000}
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	// pinned certificate, basic auth, redirect, chunked, json
+
+	d, _ := tab.GetDevice("lab1")
+	d.Attr.HTTPURLs = []string{"/redirect", "/chunked"}
+	d.Attr.HTTPAuth = "basic"
+	d.Attr.HTTPPinSHA256 = strings.ToUpper(pin)
+	d.Attr.HTTPJSONPretty = true
+	tab.UpdateDevice(d)
+
+	r := fetchOneRepo(t, tab, logger, "lab1", appConfig, repo)
+	if r.Code != fetchErrNone || r.Transport != "https" {
+		t.Errorf("pinned: code=%d transport=%s msg=%s", r.Code, r.Transport, r.Msg)
+	} else {
+		got := string(lastConfig(t, repo, "lab1"))
+		for _, want := range []string{"  \"hostname\": \"fw1\",\n", "chunk 0\nchunk 1\nchunk 2\n"} {
+			if !strings.Contains(got, want) {
+				t.Errorf("pinned: missing %q in output:\n%s", want, got)
+			}
+		}
+		if strings.Contains(got, "Content-Type") || strings.Contains(got, "HTTP/1.1") {
+			t.Errorf("pinned: response headers not stripped:\n%s", got)
+		}
+	}
+
+	// wrong pin
+
+	d.Attr.HTTPPinSHA256 = strings.Repeat("00", sha256.Size)
+	tab.UpdateDevice(d)
+
+	if r = fetchOneRepo(t, tab, logger, "lab1", appConfig, repo); r.Code != fetchErrTransp {
+		t.Errorf("wrong pin: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	// unknown CA
+
+	d.Attr.HTTPPinSHA256 = ""
+	tab.UpdateDevice(d)
+
+	if r = fetchOneRepo(t, tab, logger, "lab1", appConfig, repo); r.Code != fetchErrTransp {
+		t.Errorf("unknown CA: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	// skip verify, bearer token, custom header
+
+	d.Attr.HTTPInsecureSkipVerify = true
+	d.Attr.HTTPAuth = "bearer"
+	d.Attr.HTTPHeaders = []conf.HTTPHeader{{Name: "X-API-Key", Value: "k1"}}
+	d.Attr.HTTPURLs = []string{"https://localhost" + addr + "/token"}
+	tab.UpdateDevice(d)
+
+	if r = fetchOneRepo(t, tab, logger, "lab1", appConfig, repo); r.Code != fetchErrNone {
+		t.Errorf("bearer: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	// bad credentials
+
+	d.Attr.HTTPAuth = "basic"
+	d.Attr.HTTPURLs = []string{"/config.json"}
+	d.LoginPassword = "wrong"
+	tab.UpdateDevice(d)
+
+	if r = fetchOneRepo(t, tab, logger, "lab1", appConfig, repo); r.Code != fetchErrCommands || !strings.Contains(r.Msg, "401") {
+		t.Errorf("bad credentials: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	// body larger than capture limit
+
+	d.Attr.HTTPURLs = []string{"/chunked"}
+	d.Attr.MaxCaptureSize = 10
+	tab.UpdateDevice(d)
+
+	if r = fetchOneRepo(t, tab, logger, "lab1", appConfig, repo); r.Code != fetchErrCapture {
+		t.Errorf("capture limit: code=%d msg=%s", r.Code, r.Msg)
+	}
+}
@@ -41,6 +41,7 @@ func registerModelHuaweiVRP(logger hasPrintf, t *DeviceTable) {
 	a.CommandReadTimeout = 15 * time.Second  // larger timeout for slow 'sh run'
 	a.CommandMatchTimeout = 25 * time.Second // larger timeout for slow 'sh run'
 	a.QuoteSentCommandsFormat = `##[%s]`
+	a.EndMarkers = []conf.EndMarker{{Command: "disp curr", Pattern: `^return\s*$`}}
 
 	m := &Model{name: "huawei-vrp"}
 	m.defaultAttr = a
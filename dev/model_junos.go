@@ -26,6 +26,7 @@ func registerModelJunOS(logger hasPrintf, t *DeviceTable) {
 	a.CommandReadTimeout = 20 * time.Second  // larger timeout for slow 'sh run'
 	a.CommandMatchTimeout = 30 * time.Second // larger timeout for slow 'sh run'
 	a.QuoteSentCommandsFormat = `##[%s]`
+	a.ErrorPatterns = []string{`^\s*(syntax error|unknown command)`}
 	a.S3ContentType = "detect"
 
 	m := &Model{name: "junos"}
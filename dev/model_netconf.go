@@ -0,0 +1,21 @@
+package dev
+
+import (
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+func registerModelNetconf(logger hasPrintf, t *DeviceTable) {
+	a := conf.NewDevAttr()
+
+	a.NetconfDatastores = []string{"running"} // "candidate" also supported
+	a.CommandMatchTimeout = 60 * time.Second  // full netconf session timeout
+	a.ChangesOnly = true
+
+	m := &Model{name: "netconf"}
+	m.defaultAttr = a
+	if err := t.SetModel(m, logger); err != nil {
+		logger.Printf("registerModelNetconf: %v", err)
+	}
+}
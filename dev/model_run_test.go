@@ -0,0 +1,177 @@
+package dev
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+	"github.com/udhos/jazigo/temp"
+)
+
+func TestRunCredentials(t *testing.T) {
+
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	appConfig := &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10, MaxConfigLoadSize: 10000}
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	const tail = `echo "env=${JAZIGO_DEV_PASS-unset} user=$JAZIGO_DEV_USER"; echo oops >&2`
+
+	progs := map[string]string{
+		"env":   `echo "pass=$JAZIGO_DEV_PASS"; ` + tail,
+		"stdin": `read p; echo "pass=$p"; unset p; ` + tail,
+		"file":  `echo "pass=$(cat $JAZIGO_DEV_PASS_FILE)"; echo "file=$JAZIGO_DEV_PASS_FILE"; ` + tail,
+	}
+
+	for mode, prog := range progs {
+		id := "lab-" + mode
+		CreateDevice(tab, logger, "run", id, "localhost", "pipe", "lab", "secret-"+mode, "", false, nil)
+
+		d, _ := tab.GetDevice(id)
+		d.Attr.RunProg = []string{"/bin/bash", "-c", prog}
+		d.Attr.RunCredentials = mode
+		tab.UpdateDevice(d)
+
+		r := fetchOneRepo(t, tab, logger, id, appConfig, repo)
+		if r.Code != fetchErrNone {
+			t.Errorf("%s: code=%d msg=%s", mode, r.Code, r.Msg)
+			continue
+		}
+
+		got := lastConfig(t, repo, id)
+		if !bytes.Contains(got, []byte("pass=secret-"+mode+"\n")) {
+			t.Errorf("%s: password not received: %q", mode, got)
+		}
+		if mode != "env" && !bytes.Contains(got, []byte("env=unset user=lab\n")) {
+			t.Errorf("%s: password leaked into environment: %q", mode, got)
+		}
+		if bytes.Contains(got, []byte("oops")) {
+			t.Errorf("%s: stderr mixed into output: %q", mode, got)
+		}
+		if m := regexp.MustCompile(`file=(\S+)`).FindSubmatch(got); m != nil {
+			if _, err := os.Stat(string(m[1])); !os.IsNotExist(err) {
+				t.Errorf("%s: credentials file not removed: %s: %v", mode, m[1], err)
+			}
+		}
+	}
+
+	if v, found := os.LookupEnv("JAZIGO_DEV_PASS"); found {
+		t.Errorf("process environment modified: JAZIGO_DEV_PASS=%s", v)
+	}
+
+	// unknown mode is refused
+
+	id := "lab-bad"
+	CreateDevice(tab, logger, "run", id, "localhost", "pipe", "lab", "secret", "", false, nil)
+	d, _ := tab.GetDevice(id)
+	d.Attr.RunCredentials = "bogus"
+	tab.UpdateDevice(d)
+
+	if r := fetchOneRepo(t, tab, logger, id, appConfig, repo); r.Code != fetchErrTransp {
+		t.Errorf("bad mode: code=%d msg=%s", r.Code, r.Msg)
+	}
+}
+
+func TestRunSandbox(t *testing.T) {
+
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	appConfig := &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10, MaxConfigLoadSize: 100000}
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	run := func(id, prog string, setup func(a *conf.DevAttributes)) FetchResult {
+		CreateDevice(tab, logger, "run", id, "localhost", "pipe", "lab", "secret", "", false, nil)
+		d, _ := tab.GetDevice(id)
+		d.Attr.RunProg = []string{"/bin/bash", "-c", prog}
+		if setup != nil {
+			setup(&d.Attr)
+		}
+		tab.UpdateDevice(d)
+		return fetchOneRepo(t, tab, logger, id, appConfig, repo)
+	}
+
+	// scratch dir
+
+	r := run("lab-scratch", `echo "dir=$PWD"; touch leftover`, func(a *conf.DevAttributes) { a.RunScratchDir = true })
+	if r.Code != fetchErrNone {
+		t.Errorf("scratch: code=%d msg=%s", r.Code, r.Msg)
+	} else if m := regexp.MustCompile(`dir=(\S+)`).FindSubmatch(lastConfig(t, repo, "lab-scratch")); m == nil {
+		t.Errorf("scratch: missing working dir")
+	} else if cwd, _ := os.Getwd(); string(m[1]) == cwd {
+		t.Errorf("scratch: program ran in jazigo working dir: %s", cwd)
+	} else if _, err := os.Stat(string(m[1])); !os.IsNotExist(err) {
+		t.Errorf("scratch: dir not removed: %s: %v", m[1], err)
+	}
+
+	// non-zero exit status
+
+	r = run("lab-exit", `echo partial; exit 3`, nil)
+	if r.Code != fetchErrExit || !strings.Contains(r.Msg, "exit status 3") {
+		t.Errorf("exit: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	// output size
+
+	r = run("lab-output", `head -c 50000 /dev/zero | tr '\0' x`, func(a *conf.DevAttributes) { a.RunMaxOutputSize = 1000 })
+	if r.Code == fetchErrNone || !strings.Contains(r.Msg, "output exceeded limit") {
+		t.Errorf("output: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	r = run("lab-output-ok", `echo small`, func(a *conf.DevAttributes) { a.RunMaxOutputSize = 1000 })
+	if r.Code != fetchErrNone {
+		t.Errorf("output ok: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	// rlimits
+
+	r = run("lab-limits", `echo "cpu=$(ulimit -t) mem=$(ulimit -v)"`, func(a *conf.DevAttributes) {
+		a.RunCPULimit = 1500 * time.Millisecond
+		a.RunMemoryLimit = 1 << 30
+	})
+	if r.Code != fetchErrNone {
+		t.Errorf("limits: code=%d msg=%s", r.Code, r.Msg)
+	} else if got := lastConfig(t, repo, "lab-limits"); !bytes.Contains(got, []byte("cpu=2 mem=1048576\n")) {
+		t.Errorf("limits: not applied: %q", got)
+	}
+
+	// uid/gid
+
+	nobody, lookupErr := user.Lookup("nobody")
+	if os.Getuid() != 0 || lookupErr != nil {
+		t.Logf("run user: skipping: uid=%d lookup nobody: %v", os.Getuid(), lookupErr)
+		return
+	}
+
+	r = run("lab-user", `echo "uid=$(id -u) gid=$(id -g)"; cat $JAZIGO_DEV_PASS_FILE`, func(a *conf.DevAttributes) {
+		a.RunUser = "nobody"
+		a.RunCredentials = "file"
+		a.RunScratchDir = true
+	})
+	if r.Code != fetchErrNone {
+		t.Errorf("run user: code=%d msg=%s", r.Code, r.Msg)
+	} else if want := fmt.Sprintf("uid=%s gid=%s\nsecret", nobody.Uid, nobody.Gid); !bytes.Contains(lastConfig(t, repo, "lab-user"), []byte(want)) {
+		t.Errorf("run user: want=%q got=%q", want, lastConfig(t, repo, "lab-user"))
+	}
+
+	r = run("lab-nouser", `true`, func(a *conf.DevAttributes) { a.RunUser = "no-such-user-jazigo" })
+	if r.Code != fetchErrTransp {
+		t.Errorf("bad run user: code=%d msg=%s", r.Code, r.Msg)
+	}
+}
@@ -0,0 +1,21 @@
+package dev
+
+import (
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+func registerModelSFTP(logger hasPrintf, t *DeviceTable) {
+	a := conf.NewDevAttr()
+
+	a.RemoteFiles = []string{"/etc/hosts"}   // downloaded thru sftp or scp
+	a.CommandMatchTimeout = 60 * time.Second // full file transfer timeout
+	a.ChangesOnly = true
+
+	m := &Model{name: "sftp"}
+	m.defaultAttr = a
+	if err := t.SetModel(m, logger); err != nil {
+		logger.Printf("registerModelSFTP: %v", err)
+	}
+}
@@ -0,0 +1,448 @@
+package dev
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+const (
+	netconfBase10    = "urn:ietf:params:netconf:base:1.0"
+	netconfBase11    = "urn:ietf:params:netconf:base:1.1"
+	netconfCandidate = "urn:ietf:params:netconf:capability:candidate:1.0"
+	netconfEOM       = "]]>]]>"
+	netconfNS        = "urn:ietf:params:xml:ns:netconf:base:1.0"
+)
+
+// netconfSession exchanges NETCONF messages over the ssh netconf subsystem.
+// Framing starts as 1.0 end-of-message and switches to 1.1 chunked after hello, if both peers support it.
+type netconfSession struct {
+	r            *bufio.Reader
+	w            io.Writer
+	chunked      bool
+	maxSize      int64
+	capabilities []string
+	messageID    int
+}
+
+func (n *netconfSession) send(msg string) error {
+	var buf bytes.Buffer
+	if n.chunked {
+		fmt.Fprintf(&buf, "\n#%d\n%s\n##\n", len(msg), msg)
+	} else {
+		buf.WriteString(msg)
+		buf.WriteString(netconfEOM)
+	}
+	_, err := n.w.Write(buf.Bytes())
+	return err
+}
+
+func (n *netconfSession) recv() ([]byte, error) {
+	if n.chunked {
+		return n.recvChunked()
+	}
+	return n.recvEOM()
+}
+
+func (n *netconfSession) recvEOM() ([]byte, error) {
+	var buf []byte
+	for {
+		b, err := n.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b)
+		if bytes.HasSuffix(buf, []byte(netconfEOM)) {
+			return buf[:len(buf)-len(netconfEOM)], nil
+		}
+		if n.maxSize > 0 && int64(len(buf)) > n.maxSize {
+			return nil, fmt.Errorf("netconf: message %w: max=%d", errCaptureSize, n.maxSize)
+		}
+	}
+}
+
+// recvChunked reads RFC 6242 chunks: \n#<size>\n<data> ... \n##\n
+func (n *netconfSession) recvChunked() ([]byte, error) {
+	var buf []byte
+	for {
+		header, err := n.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if header == "\n" {
+			continue // chunk header begins with LF
+		}
+		header = strings.TrimSuffix(header, "\n")
+		if header == "##" {
+			return buf, nil // end of chunks
+		}
+		if !strings.HasPrefix(header, "#") {
+			return nil, fmt.Errorf("netconf: bad chunk header: %q", header)
+		}
+		size, sizeErr := strconv.ParseInt(header[1:], 10, 64)
+		if sizeErr != nil || size < 1 {
+			return nil, fmt.Errorf("netconf: bad chunk size: %q", header)
+		}
+		if n.maxSize > 0 && int64(len(buf))+size > n.maxSize {
+			return nil, fmt.Errorf("netconf: message %w: max=%d", errCaptureSize, n.maxSize)
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(n.r, chunk); err != nil {
+			return nil, err
+		}
+		buf = append(buf, chunk...)
+	}
+}
+
+func (n *netconfSession) hasCapability(capability string) bool {
+	for _, c := range n.capabilities {
+		if c == capability || strings.HasPrefix(c, capability+"?") {
+			return true
+		}
+	}
+	return false
+}
+
+// hello exchanges capabilities and selects framing.
+func (n *netconfSession) hello() error {
+	msg := `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<hello xmlns="` + netconfNS + `"><capabilities>` +
+		`<capability>` + netconfBase10 + `</capability>` +
+		`<capability>` + netconfBase11 + `</capability>` +
+		`</capabilities></hello>`
+	if err := n.send(msg); err != nil {
+		return fmt.Errorf("netconf hello: send: %v", err)
+	}
+
+	reply, recvErr := n.recv()
+	if recvErr != nil {
+		return fmt.Errorf("netconf hello: recv: %v", recvErr)
+	}
+
+	var hello struct {
+		XMLName      xml.Name `xml:"hello"`
+		Capabilities []string `xml:"capabilities>capability"`
+	}
+	if err := xml.Unmarshal(reply, &hello); err != nil {
+		return fmt.Errorf("netconf hello: %v", err)
+	}
+	for _, c := range hello.Capabilities {
+		n.capabilities = append(n.capabilities, strings.TrimSpace(c))
+	}
+
+	n.chunked = n.hasCapability(netconfBase11)
+
+	return nil
+}
+
+// rpc sends an operation and returns the reply.
+func (n *netconfSession) rpc(operation string) ([]byte, error) {
+	n.messageID++
+	msg := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?><rpc message-id="%d" xmlns="%s">%s</rpc>`, n.messageID, netconfNS, operation)
+	if err := n.send(msg); err != nil {
+		return nil, err
+	}
+	reply, recvErr := n.recv()
+	if recvErr != nil {
+		return nil, recvErr
+	}
+	if rpcErr := netconfError(reply); rpcErr != nil {
+		return nil, rpcErr
+	}
+	return reply, nil
+}
+
+// netconfError reports rpc-error with severity error found in reply.
+func netconfError(reply []byte) error {
+	var r struct {
+		Errors []struct {
+			Tag      string `xml:"error-tag"`
+			Severity string `xml:"error-severity"`
+			Message  string `xml:"error-message"`
+		} `xml:"rpc-error"`
+	}
+	if err := xml.Unmarshal(reply, &r); err != nil {
+		return fmt.Errorf("bad rpc-reply: %v", err)
+	}
+	for _, e := range r.Errors {
+		if strings.TrimSpace(e.Severity) == "warning" {
+			continue
+		}
+		return fmt.Errorf("rpc-error: tag=%s message=%s", strings.TrimSpace(e.Tag), strings.TrimSpace(e.Message))
+	}
+	return nil
+}
+
+// netconfDatastoreName restricts datastores to plain names like running, candidate or startup.
+var netconfDatastoreName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+func (n *netconfSession) getConfig(datastore string) ([]byte, error) {
+	if !netconfDatastoreName.MatchString(datastore) {
+		return nil, fmt.Errorf("get-config: bad datastore name: %q", datastore)
+	}
+	reply, err := n.rpc("<get-config><source><" + datastore + "/></source></get-config>")
+	if err != nil {
+		return nil, fmt.Errorf("get-config %s: %w", datastore, err)
+	}
+	return reply, nil
+}
+
+// fetchNetconf retrieves Attr.NetconfDatastores thru NETCONF instead of running commands.
+func (d *Device) fetchNetconf(ctx context.Context, logger hasPrintf, repository string, opt *conf.AppConfig, begin time.Time) FetchResult {
+	modelName := d.devModel.name
+	const transport = "netconf"
+
+	dl, auth, hostKeyCheck, optErr := d.connectOptions(ctx, logger, repository, opt)
+	if optErr != nil {
+		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("fetch netconf: %v", optErr), Code: fetchErrTransp, Begin: begin}
+	}
+
+	hostPort := forceHostPort(d.HostPort, "830")
+
+	s, clientErr := openSSHClient(logger, modelName, d.ID, hostPort, dl, auth,
+		d.DevConfig.SSHClearCiphers, d.DevConfig.SSHAddCiphers, hostKeyCheck)
+	if clientErr != nil {
+		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Msg: fmt.Sprintf("fetch netconf: %v", clientErr), Code: fetchTransportCode(clientErr), Begin: begin}
+	}
+	defer s.conn.Close()
+	defer s.client.Close()
+	stop := context.AfterFunc(ctx, func() { s.conn.Close() }) // abort blocked reads
+	defer stop()
+
+	failed := func(code int, err error) FetchResult {
+		return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Auth: s.auth, Msg: fmt.Sprintf("fetch netconf: %v", err), Code: code, Begin: begin}
+	}
+
+	if d.Attr.CommandMatchTimeout > 0 {
+		s.conn.SetDeadline(time.Now().Add(d.Attr.CommandMatchTimeout))
+	}
+
+	ses, sessionErr := s.client.NewSession()
+	if sessionErr != nil {
+		return failed(fetchErrTransp, fmt.Errorf("NewSession: %v", sessionErr))
+	}
+	defer ses.Close()
+
+	writer, wrErr := ses.StdinPipe()
+	if wrErr != nil {
+		return failed(fetchErrTransp, fmt.Errorf("StdinPipe: %v", wrErr))
+	}
+	reader, rdErr := ses.StdoutPipe()
+	if rdErr != nil {
+		return failed(fetchErrTransp, fmt.Errorf("StdoutPipe: %v", rdErr))
+	}
+
+	if subErr := ses.RequestSubsystem("netconf"); subErr != nil {
+		return failed(fetchErrTransp, fmt.Errorf("subsystem: %v", subErr))
+	}
+
+	n := &netconfSession{r: bufio.NewReader(reader), w: writer, maxSize: d.Attr.MaxCaptureSize}
+
+	if helloErr := n.hello(); helloErr != nil {
+		return failed(fetchErrLogin, helloErr)
+	}
+
+	logger.Printf("fetchNetconf: %s - hello: chunked=%v capabilities=%d", s.devLabel, n.chunked, len(n.capabilities))
+
+	var out bytes.Buffer
+
+	for _, ds := range d.Attr.NetconfDatastores {
+		if ds == "candidate" && !n.hasCapability(netconfCandidate) {
+			return failed(fetchErrCommands, fmt.Errorf("get-config candidate: capability not supported by device"))
+		}
+		reply, getErr := n.getConfig(ds)
+		if getErr != nil {
+			if errors.Is(getErr, errCaptureSize) {
+				return failed(fetchErrCapture, getErr)
+			}
+			return failed(fetchErrCommands, getErr)
+		}
+		fmt.Fprintf(&out, "<!-- datastore: %s -->\n", ds)
+		if err := netconfPrettyData(&out, reply); err != nil {
+			return failed(fetchErrCommands, fmt.Errorf("get-config %s: %v", ds, err))
+		}
+	}
+
+	if _, closeErr := n.rpc("<close-session/>"); closeErr != nil {
+		logger.Printf("fetchNetconf: %s - close-session: %v", s.devLabel, closeErr)
+	}
+
+	files := []remoteFile{{path: transport, content: out.Bytes()}}
+	if saveErr := d.saveFiles(logger, files, repository, opt.MaxConfigFiles, d.shrinkGuard(opt)); saveErr != nil {
+		return failed(saveErrCode(saveErr), saveErr)
+	}
+
+	return FetchResult{Model: modelName, DevID: d.ID, DevHostPort: d.HostPort, Transport: transport, Auth: s.auth, Code: fetchErrNone, Begin: begin}
+}
+
+// netconfPrettyData writes the contents of rpc-reply/data as indented XML.
+// Namespace declarations found on rpc-reply and data are copied into the top-level elements,
+// since the raw tokens keep prefixes unresolved.
+func netconfPrettyData(w io.Writer, reply []byte) error {
+	dec := xml.NewDecoder(bytes.NewReader(reply))
+
+	var tokens []xml.Token
+	var inherited []xml.Attr // xmlns declarations from rpc-reply and data
+	depth := 0
+	inside := false
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 || (!inside && depth == 2 && t.Name.Local == "data") {
+				inherited = xmlnsMerge(inherited, t.Attr)
+			}
+			if !inside && depth == 2 && t.Name.Local == "data" {
+				inside = true
+				continue
+			}
+			if inside && depth == 3 {
+				t = t.Copy()
+				t.Attr = xmlnsInherit(inherited, t.Attr)
+				tokens = append(tokens, t)
+				continue
+			}
+		case xml.EndElement:
+			depth--
+			if inside && depth == 1 {
+				inside = false
+				continue
+			}
+		}
+		if inside {
+			tokens = append(tokens, xml.CopyToken(tok))
+		}
+	}
+
+	return xmlPretty(w, tokens)
+}
+
+func isXMLNS(a xml.Attr) bool {
+	return a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns")
+}
+
+// xmlnsMerge adds xmlns declarations from attr into decl, replacing redeclared prefixes.
+func xmlnsMerge(decl, attr []xml.Attr) []xml.Attr {
+	for _, a := range attr {
+		if !isXMLNS(a) {
+			continue
+		}
+		decl = slices.DeleteFunc(decl, func(d xml.Attr) bool { return d.Name == a.Name })
+		decl = append(decl, a)
+	}
+	return decl
+}
+
+// xmlnsInherit prepends to attr the declarations from decl not redeclared in attr.
+func xmlnsInherit(decl, attr []xml.Attr) []xml.Attr {
+	var result []xml.Attr
+	for _, d := range decl {
+		if !slices.ContainsFunc(attr, func(a xml.Attr) bool { return a.Name == d.Name }) {
+			result = append(result, d)
+		}
+	}
+	return append(result, attr...)
+}
+
+// xmlPretty prints tokens one element per line, two-space indented.
+// Elements holding only text are kept in a single line with their text untouched.
+// Only whitespace between elements is dropped. Namespace prefixes and attributes are kept as is.
+func xmlPretty(w io.Writer, tokens []xml.Token) error {
+	bw := bufio.NewWriter(w)
+
+	name := func(n xml.Name) string {
+		if n.Space == "" {
+			return n.Local
+		}
+		return n.Space + ":" + n.Local
+	}
+
+	startTag := func(t xml.StartElement) string {
+		var b strings.Builder
+		b.WriteString("<" + name(t.Name))
+		for _, a := range t.Attr {
+			var v bytes.Buffer
+			xml.EscapeText(&v, []byte(a.Value))
+			fmt.Fprintf(&b, ` %s="%s"`, name(a.Name), v.String())
+		}
+		return b.String()
+	}
+
+	text := func(t xml.CharData) string {
+		var v bytes.Buffer
+		xml.EscapeText(&v, t)
+		return v.String()
+	}
+
+	blank := func(tok xml.Token) bool {
+		c, isText := tok.(xml.CharData)
+		return isText && len(bytes.TrimSpace(c)) == 0
+	}
+
+	// next returns index of next non-blank token
+	next := func(i int) int {
+		for i < len(tokens) && blank(tokens[i]) {
+			i++
+		}
+		return i
+	}
+
+	indent := 0
+	pad := func() string { return strings.Repeat("  ", indent) }
+
+	for i := next(0); i < len(tokens); i = next(i + 1) {
+		switch t := tokens[i].(type) {
+		case xml.StartElement:
+			if i+1 < len(tokens) {
+				if _, isEnd := tokens[i+1].(xml.EndElement); isEnd {
+					fmt.Fprintf(bw, "%s%s/>\n", pad(), startTag(t)) // empty element
+					i++
+					continue
+				}
+			}
+			if i+2 < len(tokens) {
+				c, isText := tokens[i+1].(xml.CharData)
+				if _, isEnd := tokens[i+2].(xml.EndElement); isText && isEnd {
+					fmt.Fprintf(bw, "%s%s>%s</%s>\n", pad(), startTag(t), text(c), name(t.Name)) // text-only element, whitespace included
+					i += 2
+					continue
+				}
+			}
+			fmt.Fprintf(bw, "%s%s>\n", pad(), startTag(t))
+			indent++
+		case xml.EndElement:
+			if indent > 0 {
+				indent--
+			}
+			fmt.Fprintf(bw, "%s</%s>\n", pad(), name(t.Name))
+		case xml.CharData:
+			fmt.Fprintf(bw, "%s%s\n", pad(), text(t))
+		case xml.Comment:
+			fmt.Fprintf(bw, "%s<!--%s-->\n", pad(), t)
+		case xml.ProcInst:
+			fmt.Fprintf(bw, "%s<?%s %s?>\n", pad(), t.Target, t.Inst)
+		case xml.Directive:
+			fmt.Fprintf(bw, "%s<!%s>\n", pad(), t)
+		}
+	}
+
+	return bw.Flush()
+}
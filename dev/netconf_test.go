@@ -0,0 +1,184 @@
+package dev
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/udhos/jazigo/conf"
+	"github.com/udhos/jazigo/temp"
+)
+
+const netconfTestConfig = `<configuration xmlns="http://xml.juniper.net/xnm/1.1/xnm" junos:changed-seconds="1700000000" xmlns:junos="http://xml.juniper.net/junos/*/junos"><version>20.4R3</version>
+<system><host-name>lab1</host-name><domain-name/><login><message>  a &amp; b </message></login><services nc:operation="merge"/></system></configuration>`
+
+const netconfTestPretty = `<!-- datastore: running -->
+<configuration xmlns:nc="urn:ietf:params:xml:ns:netconf:base:1.0" xmlns="http://xml.juniper.net/xnm/1.1/xnm" junos:changed-seconds="1700000000" xmlns:junos="http://xml.juniper.net/junos/*/junos">
+  <version>20.4R3</version>
+  <system>
+    <host-name>lab1</host-name>
+    <domain-name/>
+    <login>
+      <message>  a &amp; b </message>
+    </login>
+    <services nc:operation="merge"/>
+  </system>
+</configuration>
+<!-- datastore: candidate -->
+<configuration xmlns:nc="urn:ietf:params:xml:ns:netconf:base:1.0" xmlns="http://xml.juniper.net/xnm/1.1/xnm" junos:changed-seconds="1700000000" xmlns:junos="http://xml.juniper.net/junos/*/junos">
+  <version>20.4R3</version>
+  <system>
+    <host-name>lab1</host-name>
+    <domain-name/>
+    <login>
+      <message>  a &amp; b </message>
+    </login>
+    <services nc:operation="merge"/>
+  </system>
+</configuration>
+`
+
+// netconfServerSSH emulates a NETCONF server on the netconf subsystem.
+func netconfServerSSH(t *testing.T, ch ssh.Channel, base11 bool) {
+	n := &netconfSession{r: bufio.NewReader(ch), w: ch}
+
+	caps := "<capability>" + netconfBase10 + "</capability><capability>" + netconfCandidate + "</capability>"
+	if base11 {
+		caps += "<capability>" + netconfBase11 + "</capability>"
+	}
+	if err := n.send(`<hello xmlns="` + netconfNS + `"><capabilities>` + caps + `</capabilities><session-id>1</session-id></hello>`); err != nil {
+		t.Logf("netconfServerSSH: send hello: %v", err)
+		return
+	}
+
+	clientHello, helloErr := n.recv()
+	if helloErr != nil {
+		t.Logf("netconfServerSSH: recv hello: %v", helloErr)
+		return
+	}
+	n.chunked = base11 && strings.Contains(string(clientHello), netconfBase11)
+
+	for {
+		msg, recvErr := n.recv()
+		if recvErr != nil {
+			t.Logf("netconfServerSSH: recv: %v", recvErr)
+			return
+		}
+		var rpc struct {
+			MessageID string `xml:"message-id,attr"`
+			Source    struct {
+				Running   *struct{} `xml:"running"`
+				Candidate *struct{} `xml:"candidate"`
+			} `xml:"get-config>source"`
+			CloseSession *struct{} `xml:"close-session"`
+		}
+		if err := xml.Unmarshal(msg, &rpc); err != nil {
+			t.Logf("netconfServerSSH: bad rpc: %v", err)
+			return
+		}
+		var body string
+		switch {
+		case rpc.CloseSession != nil:
+			body = "<ok/>"
+		case rpc.Source.Running != nil, rpc.Source.Candidate != nil:
+			body = `<data xmlns:nc="` + netconfNS + `">` + "\n" + netconfTestConfig + "\n</data>"
+		default:
+			body = "<rpc-error><error-type>protocol</error-type><error-tag>operation-not-supported</error-tag><error-severity>error</error-severity><error-message>bad datastore</error-message></rpc-error>"
+		}
+		reply := fmt.Sprintf(`<rpc-reply message-id="%s" xmlns="%s">%s</rpc-reply>`, rpc.MessageID, netconfNS, body)
+		if err := n.send(reply); err != nil {
+			t.Logf("netconfServerSSH: send: %v", err)
+			return
+		}
+		if rpc.CloseSession != nil {
+			return
+		}
+	}
+}
+
+func TestNetconf(t *testing.T) {
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) != "pass" {
+				return nil, fmt.Errorf("bad password")
+			}
+			base := "1.1"
+			if c.User() == "lab10" {
+				base = "1.0" // server without chunked framing
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"auth": "password", "netconf": base}}, nil
+		},
+	}
+
+	// launch bogus test server
+	addr := ":2040"
+	s, listenErr := spawnServerSSH(t, addr, config)
+	if listenErr != nil {
+		t.Errorf("could not spawn bogus SSH server: %v", listenErr)
+	}
+
+	// run client test
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	appConfig := &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10, MaxConfigLoadSize: 10000}
+
+	for _, user := range []string{"lab10", "lab11"} {
+		CreateDevice(tab, logger, "netconf", user, "localhost"+addr, "netconf", user, "pass", "", false, nil)
+
+		d, _ := tab.GetDevice(user)
+		d.Attr.NetconfDatastores = []string{"running", "candidate"}
+		tab.UpdateDevice(d)
+
+		repo := temp.MakeTempRepo()
+
+		r := fetchOneRepo(t, tab, logger, user, appConfig, repo)
+		if r.Code != fetchErrNone {
+			t.Errorf("%s: code=%d msg=%s", user, r.Code, r.Msg)
+		} else if got := string(lastConfig(t, repo, user)); got != netconfTestPretty {
+			t.Errorf("%s: unexpected output:\n%s", user, got)
+		}
+
+		// rpc-error is reported
+
+		d.Attr.NetconfDatastores = []string{"startup"}
+		tab.UpdateDevice(d)
+
+		r = fetchOneRepo(t, tab, logger, user, appConfig, repo)
+		if r.Code != fetchErrCommands || !strings.Contains(r.Msg, "operation-not-supported") {
+			t.Errorf("%s rpc-error: code=%d msg=%s", user, r.Code, r.Msg)
+		}
+
+		// datastore must be a plain name
+
+		d.Attr.NetconfDatastores = []string{"running/><edit-config"}
+		tab.UpdateDevice(d)
+
+		r = fetchOneRepo(t, tab, logger, user, appConfig, repo)
+		if r.Code != fetchErrCommands || !strings.Contains(r.Msg, "bad datastore name") {
+			t.Errorf("%s bad datastore: code=%d msg=%s", user, r.Code, r.Msg)
+		}
+
+		// reply larger than capture limit
+
+		d.Attr.NetconfDatastores = []string{"running"}
+		d.Attr.MaxCaptureSize = 400 // fits hello, not get-config reply
+		tab.UpdateDevice(d)
+
+		r = fetchOneRepo(t, tab, logger, user, appConfig, repo)
+		if r.Code != fetchErrCapture {
+			t.Errorf("%s capture limit: code=%d msg=%s", user, r.Code, r.Msg)
+		}
+
+		temp.CleanupTempRepo()
+	}
+
+	s.close() // shutdown server
+
+	<-s.done // wait termination of accept loop goroutine
+}
@@ -0,0 +1,78 @@
+package dev
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/udhos/jazigo/conf"
+	"github.com/udhos/jazigo/temp"
+)
+
+func TestPagerPrompt(t *testing.T) {
+
+	// launch bogus test server
+	addr := ":2046"
+	s, listenErr := spawnServerCiscoIOS(t, addr, optionsCiscoIOS{sendUsername: true})
+	if listenErr != nil {
+		t.Fatalf("could not spawn bogus CiscoIOS server: %v", listenErr)
+	}
+	defer func() {
+		s.close()
+		<-s.done
+	}()
+
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "cisco-ios", "lab1", "localhost"+addr, "telnet", "lab", "pass", "en", false, nil)
+	d, _ := tab.GetDevice("lab1")
+	d.Attr.CommandList = []string{"more flash:big", "show run"}
+	d.Attr.PagerPromptPattern = `--More--`
+	tab.UpdateDevice(d)
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	r := fetchOneRepo(t, tab, logger, "lab1", &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10}, repo)
+	if r.Code != fetchErrNone {
+		t.Fatalf("pager: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	got := string(lastConfig(t, repo, "lab1"))
+	if !strings.Contains(got, "\npage1\n\npage2\n\npage3\n\nlast page") {
+		t.Errorf("pager: pages not captured cleanly:\n%q", got)
+	}
+	if strings.Contains(got, "More") {
+		t.Errorf("pager: prompt not scrubbed:\n%q", got)
+	}
+	if !strings.Contains(got, "show running-configuration") {
+		t.Errorf("pager: missing next command output:\n%q", got)
+	}
+}
+
+func TestScrubPagerPrompt(t *testing.T) {
+	exp := regexp.MustCompile(`-+ ?[Mm]ore ?-+`)
+	table := []struct {
+		input  string
+		output string
+		size   int
+		erase  string
+		after  string
+	}{
+		{"a\nb\n --More-- ", "a\nb\n  ", 8, "\b\b\b\b\b\b\b\b\b\bX", "\b\bX"},
+		{"a\n---- More ----", "a\n", 14, "\b\b\r", "\r"},
+		{"a\n--More--\nb", "a\n--More--\nb", 0, "\b", "\b"},
+		{"a\nmore", "a\nmore", 0, "", ""},
+	}
+	for _, e := range table {
+		out, size := scrubPagerPrompt(exp, []byte(e.input))
+		if string(out) != e.output || size != e.size {
+			t.Errorf("scrub %q: got=%q/%d want=%q/%d", e.input, out, size, e.output, e.size)
+		}
+		after, left := skipPagerErase([]byte(e.erase), size)
+		if string(after) != e.after || left != 0 {
+			t.Errorf("erase %q: got=%q/%d want=%q/0", e.erase, after, left, e.after)
+		}
+	}
+}
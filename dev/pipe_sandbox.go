@@ -0,0 +1,146 @@
+package dev
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+// pipeOptions control how the run model executes the external program.
+type pipeOptions struct {
+	timeout       time.Duration
+	credentials   string // env, stdin, file
+	scratchDir    bool   // run in temporary working directory
+	maxOutputSize int64  // 0 means unlimited
+	cpuLimit      time.Duration
+	memoryLimit   int64
+	runUser       string
+	runGroup      string
+}
+
+func newPipeOptions(a *conf.DevAttributes) pipeOptions {
+	return pipeOptions{
+		timeout:       a.RunTimeout,
+		credentials:   a.RunCredentials,
+		scratchDir:    a.RunScratchDir,
+		maxOutputSize: a.RunMaxOutputSize,
+		cpuLimit:      a.RunCPULimit,
+		memoryLimit:   a.RunMemoryLimit,
+		runUser:       a.RunUser,
+		runGroup:      a.RunGroup,
+	}
+}
+
+// lookupRunIDs resolves user and group names or numeric ids.
+// Empty name gives -1, meaning unchanged.
+// Empty group with non-empty user picks the user's primary group.
+func lookupRunIDs(runUser, runGroup string) (int, int, error) {
+	uid, gid := -1, -1
+
+	if runUser != "" {
+		u, err := user.Lookup(runUser)
+		if _, numErr := strconv.Atoi(runUser); err != nil && numErr == nil {
+			u, err = user.LookupId(runUser) // numeric id
+		}
+		if err != nil {
+			return -1, -1, fmt.Errorf("lookupRunIDs: user '%s': %v", runUser, err)
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return -1, -1, fmt.Errorf("lookupRunIDs: user '%s': bad uid: %s", runUser, u.Uid)
+		}
+		if runGroup == "" {
+			if gid, err = strconv.Atoi(u.Gid); err != nil {
+				return -1, -1, fmt.Errorf("lookupRunIDs: user '%s': bad gid: %s", runUser, u.Gid)
+			}
+		}
+	}
+
+	if runGroup != "" {
+		g, err := user.LookupGroup(runGroup)
+		if _, numErr := strconv.Atoi(runGroup); err != nil && numErr == nil {
+			g, err = user.LookupGroupId(runGroup) // numeric id
+		}
+		if err != nil {
+			return -1, -1, fmt.Errorf("lookupRunIDs: group '%s': %v", runGroup, err)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return -1, -1, fmt.Errorf("lookupRunIDs: group '%s': bad gid: %s", runGroup, g.Gid)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// chownRunIDs hands path over to the run user, if any.
+func chownRunIDs(path string, uid, gid int) error {
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// pipeGateScript holds the program until fd 3 delivers a line, then execs it in place.
+const pipeGateScript = `read -r _ <&3 || exit 126; exec 3<&-; exec "$0" "$@"`
+
+// pipeLimitGate keeps the program waiting behind /bin/sh while pipeSetLimits
+// applies the rlimits to its pid, so the program never runs unconfined.
+type pipeLimitGate struct {
+	cpu    time.Duration
+	memory int64
+	r, w   *os.File
+}
+
+// newPipeLimitGate returns nil when no limit is set.
+func newPipeLimitGate(cpu time.Duration, memory int64) (*pipeLimitGate, error) {
+	if cpu <= 0 && memory <= 0 {
+		return nil, nil
+	}
+	if !pipeRlimits {
+		return nil, fmt.Errorf("newPipeLimitGate: run cpu/memory limits supported only on linux")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("newPipeLimitGate: %v", err)
+	}
+	return &pipeLimitGate{cpu: cpu, memory: memory, r: r, w: w}, nil
+}
+
+// wrap prefixes args with the gate shell.
+func (g *pipeLimitGate) wrap(args []string) []string {
+	return append([]string{"/bin/sh", "-c", pipeGateScript}, args...)
+}
+
+// release applies the limits to the started gate shell and lets the program run.
+func (g *pipeLimitGate) release(pid int) error {
+	g.r.Close() // child holds its own copy
+	if err := pipeSetLimits(pid, g.cpu, g.memory); err != nil {
+		return err // closing w makes the gate exit
+	}
+	_, err := io.WriteString(g.w, "\n")
+	return err
+}
+
+func (g *pipeLimitGate) close() {
+	g.r.Close()
+	g.w.Close()
+}
+
+// pipeExitError reports the run model program failure, if any.
+func pipeExitError(t transp) error {
+	s, isPipe := t.(*transpPipe)
+	if !isPipe {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(s.waitErr, &exitErr) {
+		return fmt.Errorf("program failed: exit status %d: %v", exitErr.ExitCode(), exitErr)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+package dev
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// pipeSysProcAttr switches the program to uid/gid. -1 keeps the current id.
+func pipeSysProcAttr(uid, gid int) (*syscall.SysProcAttr, error) {
+	if uid == -1 && gid == -1 {
+		return nil, nil
+	}
+	if uid == -1 {
+		uid = os.Getuid()
+	}
+	if gid == -1 {
+		gid = os.Getgid()
+	}
+	return &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}}, nil
+}
+
+const pipeRlimits = true
+
+// pipeSetLimits applies CPU time and address space rlimits to the started program thru prlimit(2).
+// Zero means unlimited.
+func pipeSetLimits(pid int, cpu time.Duration, memory int64) error {
+	if cpu > 0 {
+		sec := uint64((cpu + time.Second - 1) / time.Second) // round up
+		lim := &unix.Rlimit{Cur: sec, Max: sec}
+		if err := unix.Prlimit(pid, unix.RLIMIT_CPU, lim, nil); err != nil {
+			return fmt.Errorf("pipeSetLimits: cpu: %v", err)
+		}
+	}
+	if memory > 0 {
+		lim := &unix.Rlimit{Cur: uint64(memory), Max: uint64(memory)}
+		if err := unix.Prlimit(pid, unix.RLIMIT_AS, lim, nil); err != nil {
+			return fmt.Errorf("pipeSetLimits: memory: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package dev
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
+
+func pipeSysProcAttr(uid, gid int) (*syscall.SysProcAttr, error) {
+	if uid == -1 && gid == -1 {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("pipeSysProcAttr: run user/group supported only on linux")
+}
+
+const pipeRlimits = false
+
+func pipeSetLimits(pid int, cpu time.Duration, memory int64) error {
+	return fmt.Errorf("pipeSetLimits: run cpu/memory limits supported only on linux")
+}
@@ -0,0 +1,288 @@
+package dev
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// proxyDirect disables the global default proxy for a device.
+const proxyDirect = "direct"
+
+// proxyDialer opens a TCP connection to address, possibly thru a proxy.
+// Connections are opened with the local dial options in nd.
+type proxyDialer interface {
+	dial(nd *netDialer, address string) (net.Conn, error)
+}
+
+// proxySchemes maps proxy URL scheme to dialer constructor.
+var proxySchemes = map[string]func(u *url.URL) proxyDialer{
+	"socks5":  newSocks5Dialer,
+	"socks5h": newSocks5Dialer,
+	"http":    newHTTPConnectDialer,
+}
+
+// newProxyDialer creates a dialer from proxy URL.
+// Empty URL or "direct" means no proxy.
+func newProxyDialer(proxyURL string) (proxyDialer, error) {
+	if proxyURL == "" || proxyURL == proxyDirect {
+		return directDialer{}, nil
+	}
+	u, parseErr := url.Parse(proxyURL)
+	if parseErr != nil {
+		return nil, fmt.Errorf("newProxyDialer: bad proxy URL: %v", parseErr)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("newProxyDialer: missing proxy host: '%s'", u.Redacted())
+	}
+	create, found := proxySchemes[u.Scheme]
+	if !found {
+		return nil, fmt.Errorf("newProxyDialer: unsupported proxy scheme: '%s'", u.Scheme)
+	}
+	return create(u), nil
+}
+
+// selectProxy picks device proxy, if any, else the global default.
+func selectProxy(devProxy, defaultProxy string) string {
+	if devProxy != "" {
+		return devProxy
+	}
+	return defaultProxy
+}
+
+type directDialer struct{}
+
+func (directDialer) dial(nd *netDialer, address string) (net.Conn, error) {
+	return nd.dial(address)
+}
+
+// proxyServer holds the proxy address and optional credentials.
+type proxyServer struct {
+	hostPort string
+	user     string
+	pass     string
+	hasAuth  bool
+}
+
+func newProxyServer(u *url.URL, defaultPort string) proxyServer {
+	p := proxyServer{hostPort: forceHostPort(u.Host, defaultPort)}
+	if u.User != nil {
+		p.user = u.User.Username()
+		p.pass, _ = u.User.Password()
+		p.hasAuth = true
+	}
+	return p
+}
+
+// connect dials the proxy and arms the deadline for the proxy handshake.
+func (p proxyServer) connect(nd *netDialer) (net.Conn, error) {
+	conn, dialErr := nd.dial(p.hostPort)
+	if dialErr != nil {
+		return nil, dialErr
+	}
+	conn.SetDeadline(time.Now().Add(nd.timeout))
+	return conn, nil
+}
+
+type socks5Dialer struct {
+	proxyServer
+}
+
+func newSocks5Dialer(u *url.URL) proxyDialer {
+	return &socks5Dialer{newProxyServer(u, "1080")}
+}
+
+const (
+	socks5Version      = 5
+	socks5AuthNone     = 0
+	socks5AuthPassword = 2
+	socks5AuthNoAccept = 0xff
+	socks5CmdConnect   = 1
+	socks5AddrIPv4     = 1
+	socks5AddrDomain   = 3
+	socks5AddrIPv6     = 4
+)
+
+// dial performs RFC 1928 CONNECT with optional RFC 1929 username/password auth.
+// The host name is sent to the proxy for remote resolution.
+func (d *socks5Dialer) dial(nd *netDialer, address string) (net.Conn, error) {
+	host, portStr, splitErr := net.SplitHostPort(address)
+	if splitErr != nil {
+		return nil, fmt.Errorf("socks5: %v", splitErr)
+	}
+	port, portErr := strconv.ParseUint(portStr, 10, 16)
+	if portErr != nil {
+		return nil, fmt.Errorf("socks5: bad port: %v", portErr)
+	}
+	if len(host) > 255 {
+		return nil, fmt.Errorf("socks5: host name too long: %s", host)
+	}
+
+	conn, dialErr := d.connect(nd)
+	if dialErr != nil {
+		return nil, fmt.Errorf("socks5: proxy %s: %v", d.hostPort, dialErr)
+	}
+
+	if err := d.handshake(conn, host, uint16(port)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks5: proxy %s: connect %s: %v", d.hostPort, address, err)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+func (d *socks5Dialer) handshake(conn net.Conn, host string, port uint16) error {
+
+	// method negotiation
+
+	method := byte(socks5AuthNone)
+	if d.hasAuth {
+		method = socks5AuthPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected version: %d", reply[0])
+	}
+	if reply[1] == socks5AuthNoAccept || reply[1] != method {
+		return fmt.Errorf("auth method refused: %d", reply[1])
+	}
+
+	// username/password
+
+	if method == socks5AuthPassword {
+		if len(d.user) > 255 || len(d.pass) > 255 {
+			return fmt.Errorf("username or password too long")
+		}
+		req := []byte{1, byte(len(d.user))}
+		req = append(req, d.user...)
+		req = append(req, byte(len(d.pass)))
+		req = append(req, d.pass...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return fmt.Errorf("authentication failed: status=%d", reply[1])
+		}
+	}
+
+	// connect
+
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return err
+	}
+	if head[1] != 0 {
+		return fmt.Errorf("request failed: reply=%d", head[1])
+	}
+
+	// discard bound address
+	var skip int
+	switch head[3] {
+	case socks5AddrIPv4:
+		skip = net.IPv4len
+	case socks5AddrIPv6:
+		skip = net.IPv6len
+	case socks5AddrDomain:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return err
+		}
+		skip = int(size[0])
+	default:
+		return fmt.Errorf("unexpected address type: %d", head[3])
+	}
+	_, err := io.ReadFull(conn, make([]byte, skip+2)) // address + port
+	return err
+}
+
+type httpConnectDialer struct {
+	proxyServer
+}
+
+func newHTTPConnectDialer(u *url.URL) proxyDialer {
+	return &httpConnectDialer{newProxyServer(u, "80")}
+}
+
+// dial issues HTTP CONNECT and hands over the raw connection.
+func (d *httpConnectDialer) dial(nd *netDialer, address string) (net.Conn, error) {
+
+	conn, dialErr := d.connect(nd)
+	if dialErr != nil {
+		return nil, fmt.Errorf("http connect: proxy %s: %v", d.hostPort, dialErr)
+	}
+
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if d.hasAuth {
+		cred := base64.StdEncoding.EncodeToString([]byte(d.user + ":" + d.pass))
+		req += "Proxy-Authorization: Basic " + cred + "\r\n"
+	}
+	req += "\r\n"
+
+	if _, err := io.WriteString(conn, req); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("http connect: proxy %s: %v", d.hostPort, err)
+	}
+
+	// read response byte by byte: bufio must not swallow data past the header
+	resp, respErr := http.ReadResponse(bufio.NewReaderSize(&byteReader{conn}, 1), nil)
+	if respErr != nil {
+		conn.Close()
+		return nil, fmt.Errorf("http connect: proxy %s: connect %s: %v", d.hostPort, address, respErr)
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("http connect: proxy %s: connect %s: %s", d.hostPort, address, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// byteReader limits every read to a single byte.
+type byteReader struct {
+	r io.Reader
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return b.r.Read(p)
+}
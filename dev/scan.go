@@ -1,17 +1,27 @@
 package dev
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/udhos/jazigo/conf"
 )
 
 // Spawner launches new goroutines to fetch requests received on channel reqChan.
-func Spawner(tab DeviceUpdater, logger hasPrintf, reqChan chan FetchRequest, repository, logPathPrefix string, options *conf.Options, ft *FilterTable) {
+// Cancelling ctx aborts all fetches in progress; Spawner itself exits only when reqChan is closed,
+// after waiting for the fetches in progress.
+// Each fetch is registered in tab while in progress, so it can be aborted with tab.AbortFetch.
+// A request for a device already being fetched joins the fetch in progress and gets its result,
+// instead of starting a parallel session. A shared fetch is cancelled by a request context only when
+// every request joined to it is cancelled; a request cancelled earlier gets an aborted result at once.
+func Spawner(ctx context.Context, tab *DeviceTable, logger hasPrintf, reqChan chan FetchRequest, repository, logPathPrefix string, options *conf.Options, ft *FilterTable) {
 
 	logger.Printf("Spawner: starting")
 
+	var inflight sync.WaitGroup
+
 	for {
 		req, ok := <-reqChan
 		if !ok {
@@ -19,27 +29,50 @@ func Spawner(tab DeviceUpdater, logger hasPrintf, reqChan chan FetchRequest, rep
 			break
 		}
 
-		replyChan := req.ReplyChan // alias
-
 		devID := req.ID
 		d, getErr := tab.GetDevice(devID)
 		if getErr != nil {
-			if replyChan != nil {
+			if req.ReplyChan != nil {
 				now := time.Now()
-				replyChan <- FetchResult{DevID: devID, Msg: fmt.Sprintf("Spawner: could not find device: %v", getErr), Code: fetchErrGetDev, Begin: now, End: now}
+				req.ReplyChan <- FetchResult{DevID: devID, Msg: fmt.Sprintf("Spawner: could not find device: %v", getErr), Code: fetchErrGetDev, Begin: now, End: now}
 			}
 			continue
 		}
 
-		opt := options.Get()                                                      // get current global data
-		go d.Fetch(tab, logger, replyChan, 0, repository, logPathPrefix, opt, ft) // spawn per-request goroutine
+		if tab.fetchJoin(devID, req) {
+			logger.Printf("Spawner: %s: fetch in progress: joined", devID)
+			continue
+		}
+
+		fetchCtx, cancel := context.WithCancel(ctx) // request contexts cancel thru the registry
+		running := tab.fetchBegin(devID, cancel, req)
+
+		opt := options.Get() // get current global data
+		inflight.Add(1)
+		go func() { // spawn per-request goroutine
+			defer inflight.Done()
+			resultCh := make(chan FetchResult, 1)
+			d.Fetch(fetchCtx, tab, logger, resultCh, 0, repository, logPathPrefix, opt, ft)
+			cancel()
+			result := <-resultCh
+			waiters := tab.fetchEnd(devID, running) // no request joins after this point
+			for _, w := range waiters {
+				w <- result
+			}
+		}()
 	}
 
+	logger.Printf("Spawner: waiting fetches in progress")
+	inflight.Wait()
+
 	logger.Printf("Spawner: exiting")
 }
 
 // Scan scans the list of devices dispatching backup requests to the Spawner thru the request channel reqChan.
-func Scan(tab DeviceUpdater, devices []*Device, logger hasPrintf, opt *conf.AppConfig, reqChan chan FetchRequest) (int, int, int) {
+// Cancelling ctx aborts the scan: fetches in progress are cancelled and no further device is launched.
+// Closing stop ends the scan gracefully: no further device is launched, fetches in progress run to completion.
+// A nil stop channel never ends the scan.
+func Scan(ctx context.Context, stop <-chan struct{}, tab DeviceUpdater, devices []*Device, logger hasPrintf, opt *conf.AppConfig, reqChan chan FetchRequest) (int, int, int) {
 
 	deviceCount := len(devices)
 	if deviceCount < 1 {
@@ -50,7 +83,7 @@ func Scan(tab DeviceUpdater, devices []*Device, logger hasPrintf, opt *conf.AppC
 	begin := time.Now()
 	wait := 0       // requests pending
 	nextDevice := 0 // device iterator
-	req := FetchRequest{ReplyChan: make(chan FetchResult)}
+	req := FetchRequest{ReplyChan: make(chan FetchResult), Ctx: ctx}
 	maxConcurrency := opt.MaxConcurrency // alias
 	holdtime := opt.Holdtime             // alias
 	elapMax := 0 * time.Second
@@ -66,6 +99,13 @@ func Scan(tab DeviceUpdater, devices []*Device, logger hasPrintf, opt *conf.AppC
 				break // max concurrent limit reached
 			}
 
+			if reason := scanStopped(ctx, stop); reason != "" {
+				logger.Printf("Scan: %s: skipping remaining %d devices", reason, deviceCount-nextDevice)
+				skipped += deviceCount - nextDevice
+				nextDevice = deviceCount
+				break
+			}
+
 			d := devices[nextDevice]
 
 			if d.Deleted {
@@ -97,7 +137,7 @@ func Scan(tab DeviceUpdater, devices []*Device, logger hasPrintf, opt *conf.AppC
 
 		end := time.Now()
 		elap := end.Sub(r.Begin)
-		logger.Printf("Scan: recv %s %s %s %s msg=[%s] code=%d wait=%d remain=%d skipped=%d elap=%s", r.Model, r.DevID, r.DevHostPort, r.Transport, r.Msg, r.Code, wait, deviceCount-nextDevice, skipped, elap)
+		logger.Printf("Scan: recv %s %s %s %s auth=%s msg=[%s] code=%d wait=%d remain=%d skipped=%d elap=%s", r.Model, r.DevID, r.DevHostPort, r.Transport, r.Auth, r.Msg, r.Code, wait, deviceCount-nextDevice, skipped, elap)
 
 		good := r.Code == fetchErrNone
 
@@ -120,6 +160,19 @@ func Scan(tab DeviceUpdater, devices []*Device, logger hasPrintf, opt *conf.AppC
 	return success, deviceCount - success, skipped + deleted
 }
 
+// scanStopped reports why the scan should not launch further devices, empty if it should go on.
+func scanStopped(ctx context.Context, stop <-chan struct{}) string {
+	if ctx.Err() != nil {
+		return "aborted"
+	}
+	select {
+	case <-stop:
+		return "stopped"
+	default:
+	}
+	return ""
+}
+
 func updateDeviceStatus(tab DeviceUpdater, devID string, good bool, last time.Time, elapsed time.Duration, logger hasPrintf, holdtime time.Duration) {
 	d, getErr := tab.GetDevice(devID)
 	if getErr != nil {
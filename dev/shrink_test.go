@@ -0,0 +1,85 @@
+package dev
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/udhos/jazigo/conf"
+	"github.com/udhos/jazigo/store"
+	"github.com/udhos/jazigo/temp"
+)
+
+func TestShrinkGuard(t *testing.T) {
+
+	// launch bogus test server
+	addr := ":2050"
+	s, listenErr := spawnServerCiscoIOS(t, addr, optionsCiscoIOS{sendUsername: true})
+	if listenErr != nil {
+		t.Fatalf("could not spawn bogus CiscoIOS server: %v", listenErr)
+	}
+	defer func() {
+		s.close()
+		<-s.done
+	}()
+
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "cisco-ios", "lab1", "localhost"+addr, "telnet", "lab", "pass", "en", false, nil)
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	appConfig := &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10, ShrinkPercent: 30}
+
+	if r := fetchOneRepo(t, tab, logger, "lab1", appConfig, repo); r.Code != fetchErrNone {
+		t.Fatalf("first: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	// drop half of the output
+	d, _ := tab.GetDevice("lab1")
+	d.Attr.CommandList = []string{"show run"}
+	tab.UpdateDevice(d)
+
+	r := fetchOneRepo(t, tab, logger, "lab1", appConfig, repo)
+	if r.Code != fetchErrShrink {
+		t.Fatalf("shrunk: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	prefix := DeviceFullPrefix(repo, "lab1")
+	if !store.FileExists(store.QuarantinePath(prefix)) {
+		t.Errorf("shrunk: missing quarantine file")
+	}
+	if last, _ := store.FindLastConfig(prefix, logger); filepath.Base(last) != "lab1.0" {
+		t.Errorf("shrunk: last config changed: %s", last)
+	}
+	if d, _ = tab.GetDevice("lab1"); !d.Quarantined() {
+		t.Errorf("shrunk: device not flagged as quarantined")
+	}
+
+	// flag cleared by discard, reloaded from repository at startup
+	SetDeviceQuarantine(tab, "lab1", false, logger)
+	if d, _ = tab.GetDevice("lab1"); d.Quarantined() {
+		t.Errorf("quarantine flag not cleared")
+	}
+	UpdateLastSuccess(tab, logger, repo)
+	if d, _ = tab.GetDevice("lab1"); !d.Quarantined() {
+		t.Errorf("quarantine flag not loaded from repository")
+	}
+
+	// device disables global guard
+	d.Attr.ShrinkPercent = -1
+	tab.UpdateDevice(d)
+
+	if r := fetchOneRepo(t, tab, logger, "lab1", appConfig, repo); r.Code != fetchErrNone {
+		t.Fatalf("guard disabled: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	// good backup supersedes quarantine
+	if store.FileExists(store.QuarantinePath(prefix)) {
+		t.Errorf("good backup: quarantine file left behind")
+	}
+	if d, _ = tab.GetDevice("lab1"); d.Quarantined() {
+		t.Errorf("good backup: device still flagged as quarantined")
+	}
+}
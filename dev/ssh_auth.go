@@ -0,0 +1,193 @@
+package dev
+
+import (
+	"io"
+	"net"
+	"os"
+	"regexp"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+const (
+	authAgent               = "publickey-agent"
+	authKeyFile             = "publickey-file"
+	authPassword            = "password"
+	authKeyboardInteractive = "keyboard-interactive"
+)
+
+var passwordChallenge = regexp.MustCompile(`(?i)pass(word|code|wd)`)
+
+// sshAuthOptions holds the credentials used to build the SSH auth method list.
+type sshAuthOptions struct {
+	user          string
+	password      string
+	keyFile       string                // private key file
+	keyPassphrase string                // optional passphrase for private key file
+	agent         bool                  // use ssh-agent
+	agentSocket   string                // ssh-agent socket path, empty means $SSH_AUTH_SOCK
+	challenges    []conf.PromptResponse // keyboard-interactive answers for non-password challenges
+}
+
+// sshAuthTracker records the last auth method attempted during the handshake.
+// Since the client stops trying methods on success, after a successful
+// handshake it holds the method that succeeded.
+type sshAuthTracker struct {
+	last  string
+	agent io.Closer // ssh-agent connection, if any
+}
+
+func (tr *sshAuthTracker) close() {
+	if tr.agent != nil {
+		tr.agent.Close()
+		tr.agent = nil
+	}
+}
+
+// trackedSigner reports to the tracker when the server accepted the key and the client signs with it.
+type trackedSigner struct {
+	ssh.AlgorithmSigner
+	tracker *sshAuthTracker
+	label   string
+}
+
+func (s *trackedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.tracker.last = s.label
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+func (s *trackedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.tracker.last = s.label
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+type trackedMultiSigner struct {
+	trackedSigner
+	algorithms []string
+}
+
+func (s *trackedMultiSigner) Algorithms() []string {
+	return s.algorithms
+}
+
+// trackSigner wraps signer preserving the signature algorithms it supports.
+func trackSigner(signer ssh.Signer, tracker *sshAuthTracker, label string) ssh.Signer {
+	switch s := signer.(type) {
+	case ssh.MultiAlgorithmSigner:
+		return &trackedMultiSigner{trackedSigner: trackedSigner{AlgorithmSigner: s, tracker: tracker, label: label}, algorithms: s.Algorithms()}
+	case ssh.AlgorithmSigner:
+		return &trackedSigner{AlgorithmSigner: s, tracker: tracker, label: label}
+	}
+	return signer // can't track
+}
+
+func loadKeyFile(path, passphrase string) (ssh.Signer, error) {
+	b, readErr := os.ReadFile(path)
+	if readErr != nil {
+		return nil, readErr
+	}
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(b, []byte(passphrase))
+	}
+	return ssh.ParsePrivateKey(b)
+}
+
+// sshAuthMethods builds the auth method list: public keys from ssh-agent and key file, then password as fallback.
+func sshAuthMethods(logger hasPrintf, devLabel string, opt *sshAuthOptions) ([]ssh.AuthMethod, *sshAuthTracker) {
+
+	tracker := &sshAuthTracker{}
+
+	var signers []ssh.Signer
+
+	if opt.agent {
+		socket := opt.agentSocket
+		if socket == "" {
+			socket = os.Getenv("SSH_AUTH_SOCK")
+		}
+		conn, dialErr := net.Dial("unix", socket)
+		if dialErr != nil {
+			logger.Printf("sshAuthMethods: %s: could not connect to ssh-agent '%s': %v", devLabel, socket, dialErr)
+		} else {
+			tracker.agent = conn
+			agentSigners, agentErr := agent.NewClient(conn).Signers()
+			if agentErr != nil {
+				logger.Printf("sshAuthMethods: %s: could not get keys from ssh-agent '%s': %v", devLabel, socket, agentErr)
+			}
+			for _, s := range agentSigners {
+				signers = append(signers, trackSigner(s, tracker, authAgent))
+			}
+		}
+	}
+
+	if opt.keyFile != "" {
+		signer, keyErr := loadKeyFile(opt.keyFile, opt.keyPassphrase)
+		if keyErr != nil {
+			logger.Printf("sshAuthMethods: %s: could not load private key '%s': %v", devLabel, opt.keyFile, keyErr)
+		} else {
+			signers = append(signers, trackSigner(signer, tracker, authKeyFile))
+		}
+	}
+
+	var methods []ssh.AuthMethod
+
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if opt.password != "" || len(methods) == 0 {
+		methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+			tracker.last = authPassword
+			return opt.password, nil
+		}))
+	}
+
+	methods = append(methods, ssh.KeyboardInteractive(keyboardInteractive(logger, devLabel, opt, tracker)))
+
+	return methods, tracker
+}
+
+// keyboardInteractive answers each challenge with the first matching prompt/response pair.
+// Challenges matching no pair and looking like a password prompt get the login password.
+func keyboardInteractive(logger hasPrintf, devLabel string, opt *sshAuthOptions, tracker *sshAuthTracker) ssh.KeyboardInteractiveChallenge {
+
+	type challenge struct {
+		exp      *regexp.Regexp
+		response string
+	}
+
+	var challenges []challenge
+	for _, c := range opt.challenges {
+		exp, badExp := regexp.Compile(c.Pattern)
+		if badExp != nil {
+			logger.Printf("keyboardInteractive: %s: bad challenge pattern '%s': %v", devLabel, c.Pattern, badExp)
+			continue
+		}
+		challenges = append(challenges, challenge{exp: exp, response: c.Response})
+	}
+
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		tracker.last = authKeyboardInteractive
+
+		answers := make([]string, len(questions))
+
+	QUESTION:
+		for i, q := range questions {
+			for _, c := range challenges {
+				if c.exp.MatchString(q) {
+					answers[i] = c.response
+					continue QUESTION
+				}
+			}
+			if passwordChallenge.MatchString(q) {
+				answers[i] = opt.password
+				continue
+			}
+			logger.Printf("keyboardInteractive: %s: no answer for challenge: [%s]", devLabel, q)
+		}
+
+		return answers, nil
+	}
+}
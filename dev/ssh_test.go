@@ -0,0 +1,449 @@
+package dev
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/udhos/jazigo/conf"
+	"github.com/udhos/jazigo/temp"
+)
+
+func newTestSigner(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	_, priv, genErr := ed25519.GenerateKey(rand.Reader)
+	if genErr != nil {
+		t.Fatalf("newTestSigner: %v", genErr)
+	}
+	signer, signerErr := ssh.NewSignerFromKey(priv)
+	if signerErr != nil {
+		t.Fatalf("newTestSigner: %v", signerErr)
+	}
+	return signer, priv
+}
+
+func writeTestKeyFile(t *testing.T, path string, priv ed25519.PrivateKey, passphrase string) {
+	var block *pem.Block
+	var err error
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatalf("writeTestKeyFile: %v", err)
+	}
+	if writeErr := os.WriteFile(path, pem.EncodeToMemory(block), 0600); writeErr != nil {
+		t.Fatalf("writeTestKeyFile: %v", writeErr)
+	}
+}
+
+func spawnServerSSH(t *testing.T, addr string, config *ssh.ServerConfig) (*testServer, error) {
+
+	hostKey, _ := newTestSigner(t)
+	config.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &testServer{listener: ln, done: make(chan int)}
+
+	go func() {
+		for {
+			conn, acceptErr := ln.Accept()
+			if acceptErr != nil {
+				t.Logf("spawnServerSSH: accept failure, exiting: %v", acceptErr)
+				break
+			}
+			go handleConnectionSSH(t, conn, config)
+		}
+		close(s.done)
+	}()
+
+	return s, nil
+}
+
+func handleConnectionSSH(t *testing.T, c net.Conn, config *ssh.ServerConfig) {
+	defer c.Close()
+
+	conn, chans, reqs, handshakeErr := ssh.NewServerConn(c, config)
+	if handshakeErr != nil {
+		t.Logf("handleConnectionSSH: handshake: %v", handshakeErr)
+		return
+	}
+	defer conn.Close()
+
+	t.Logf("handleConnectionSSH: user=%s auth=%s", conn.User(), conn.Permissions.Extensions["auth"])
+
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			go handleSessionSSH(t, newChannel, conn.Permissions)
+		case "direct-tcpip":
+			go handleDirectTCPIP(t, newChannel)
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		}
+	}
+}
+
+func handleSessionSSH(t *testing.T, newChannel ssh.NewChannel, perms *ssh.Permissions) {
+	ch, reqs, acceptErr := newChannel.Accept()
+	if acceptErr != nil {
+		t.Logf("handleSessionSSH: accept: %v", acceptErr)
+		return
+	}
+	defer ch.Close()
+
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req":
+			req.Reply(true, nil)
+		case "shell":
+			req.Reply(true, nil)
+			go func() {
+				shellSSH(t, ch)
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				ch.Close()
+			}()
+		case "subsystem":
+			var sub struct{ Name string }
+			ssh.Unmarshal(req.Payload, &sub)
+			if sub.Name == "netconf" {
+				req.Reply(true, nil)
+				go func() {
+					netconfServerSSH(t, ch, perms.Extensions["netconf"] != "1.0")
+					ch.Close()
+				}()
+				continue
+			}
+			if sub.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go func() {
+				server, serverErr := sftp.NewServer(ch, sftp.ReadOnly())
+				if serverErr != nil {
+					t.Logf("handleSessionSSH: sftp: %v", serverErr)
+					ch.Close()
+					return
+				}
+				server.Serve()
+				ch.Close()
+			}()
+		case "exec":
+			var cmd struct{ Command string }
+			ssh.Unmarshal(req.Payload, &cmd)
+			if !strings.HasPrefix(cmd.Command, "scp -f ") {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go func() {
+				status := scpSourceSSH(t, ch, strings.Trim(strings.TrimPrefix(cmd.Command, "scp -f "), "'"))
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				ch.Close()
+			}()
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// handleDirectTCPIP forwards the channel to the requested address, as a jump host would do.
+func handleDirectTCPIP(t *testing.T, newChannel ssh.NewChannel) {
+	var target struct {
+		Addr     string
+		Port     uint32
+		OrigAddr string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	hostPort := net.JoinHostPort(target.Addr, fmt.Sprintf("%d", target.Port))
+	conn, dialErr := net.Dial("tcp", hostPort)
+	if dialErr != nil {
+		newChannel.Reject(ssh.ConnectionFailed, dialErr.Error())
+		return
+	}
+
+	ch, reqs, acceptErr := newChannel.Accept()
+	if acceptErr != nil {
+		t.Logf("handleDirectTCPIP: accept: %v", acceptErr)
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	t.Logf("handleDirectTCPIP: forwarding to %s", hostPort)
+
+	go func() {
+		io.Copy(conn, ch)
+		conn.Close()
+	}()
+	io.Copy(ch, conn)
+	ch.Close()
+}
+
+// scpSourceSSH emulates the remote side of 'scp -f path'.
+func scpSourceSSH(t *testing.T, ch ssh.Channel, path string) uint32 {
+	ack := make([]byte, 1)
+	if _, err := io.ReadFull(ch, ack); err != nil {
+		return 1
+	}
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		fmt.Fprintf(ch, "\x01scp: %s: No such file or directory\n", path)
+		return 1
+	}
+	fmt.Fprintf(ch, "C0644 %d %s\n", len(content), filepath.Base(path))
+	if _, err := io.ReadFull(ch, ack); err != nil {
+		return 1
+	}
+	ch.Write(content)
+	ch.Write([]byte{0})
+	if _, err := io.ReadFull(ch, ack); err != nil {
+		return 1
+	}
+	t.Logf("scpSourceSSH: sent %s: %d bytes", path, len(content))
+	return 0
+}
+
+// shellSSH emulates a linux shell.
+func shellSSH(t *testing.T, ch ssh.Channel) {
+	if _, err := ch.Write([]byte("bogus ssh server\r\n$ ")); err != nil {
+		t.Logf("shellSSH: send banner error: %v", err)
+		return
+	}
+
+	buf := make([]byte, 1000)
+	var line []byte
+
+	for {
+		n, readErr := ch.Read(buf)
+		if readErr != nil {
+			return
+		}
+		for _, b := range buf[:n] {
+			if b != '\n' && b != '\r' {
+				line = append(line, b)
+				continue
+			}
+			cmd := strings.TrimSpace(string(line))
+			line = line[:0]
+			if cmd == "exit" {
+				return
+			}
+			if _, err := ch.Write([]byte(fmt.Sprintf("output for [%s]\r\n$ ", cmd))); err != nil {
+				t.Logf("shellSSH: send output error: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// fetchOne fetches a single device and returns its result.
+func fetchOne(t *testing.T, tab *DeviceTable, logger hasPrintf, id string) FetchResult {
+	return fetchOneOpt(t, tab, logger, id, &conf.AppConfig{MaxConcurrency: 3, MaxConfigFiles: 10})
+}
+
+// fetchOneOpt fetches a single device under the given global options.
+func fetchOneOpt(t *testing.T, tab *DeviceTable, logger hasPrintf, id string, appConfig *conf.AppConfig) FetchResult {
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	return fetchOneRepo(t, tab, logger, id, appConfig, repo)
+}
+
+// fetchOneRepo fetches a single device saving into repository repo.
+func fetchOneRepo(t *testing.T, tab *DeviceTable, logger hasPrintf, id string, appConfig *conf.AppConfig, repo string) FetchResult {
+	opt := conf.NewOptions()
+	opt.Set(appConfig)
+
+	requestCh := make(chan FetchRequest)
+	errlogPrefix := filepath.Join(repo, "errlog_test.")
+	done := make(chan struct{})
+	go func() {
+		Spawner(context.Background(), tab, logger, requestCh, repo, errlogPrefix, opt, NewFilterTable(logger))
+		close(done)
+	}()
+
+	replyCh := make(chan FetchResult)
+	requestCh <- FetchRequest{ID: id, ReplyChan: replyCh}
+	r := <-replyCh
+
+	close(requestCh) // shutdown Spawner
+	<-done           // do not log after test completion
+
+	return r
+}
+
+func TestSSHPublicKey(t *testing.T) {
+
+	clientSigner, clientKey := newTestSigner(t)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(clientSigner.PublicKey().Marshal()) {
+				return &ssh.Permissions{Extensions: map[string]string{"auth": "publickey"}}, nil
+			}
+			return nil, fmt.Errorf("unknown public key")
+		},
+	}
+
+	// launch bogus test server
+	addr := ":2021"
+	s, listenErr := spawnServerSSH(t, addr, config)
+	if listenErr != nil {
+		t.Errorf("could not spawn bogus SSH server: %v", listenErr)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	writeTestKeyFile(t, keyFile, clientKey, "secret")
+
+	// run client test
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "linux", "lab1", "localhost"+addr, "ssh", "lab", "", "", false, nil)
+
+	d, _ := tab.GetDevice("lab1")
+	d.SSHKeyFile = keyFile
+	d.SSHKeyPassphrase = "secret"
+	tab.UpdateDevice(d)
+
+	r := fetchOne(t, tab, logger, "lab1")
+	if r.Code != fetchErrNone {
+		t.Errorf("publickey: code=%d msg=%s", r.Code, r.Msg)
+	}
+	if r.Auth != authKeyFile {
+		t.Errorf("publickey: auth=%s wanted=%s", r.Auth, authKeyFile)
+	}
+
+	s.close() // shutdown server
+
+	<-s.done // wait termination of accept loop goroutine
+}
+
+func TestSSHPasswordFallback(t *testing.T) {
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, fmt.Errorf("no public key accepted")
+		},
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "lab" && string(pass) == "pass" {
+				return &ssh.Permissions{Extensions: map[string]string{"auth": "password"}}, nil
+			}
+			return nil, fmt.Errorf("bad password")
+		},
+	}
+
+	// launch bogus test server
+	addr := ":2022"
+	s, listenErr := spawnServerSSH(t, addr, config)
+	if listenErr != nil {
+		t.Errorf("could not spawn bogus SSH server: %v", listenErr)
+	}
+
+	_, otherKey := newTestSigner(t)
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	writeTestKeyFile(t, keyFile, otherKey, "")
+
+	// run client test
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "linux", "lab1", "localhost"+addr, "ssh", "lab", "pass", "", false, nil)
+
+	d, _ := tab.GetDevice("lab1")
+	d.SSHKeyFile = keyFile
+	tab.UpdateDevice(d)
+
+	r := fetchOne(t, tab, logger, "lab1")
+	if r.Code != fetchErrNone {
+		t.Errorf("password fallback: code=%d msg=%s", r.Code, r.Msg)
+	}
+	if r.Auth != authPassword {
+		t.Errorf("password fallback: auth=%s wanted=%s", r.Auth, authPassword)
+	}
+
+	s.close() // shutdown server
+
+	<-s.done // wait termination of accept loop goroutine
+}
+
+func TestSSHKeyboardInteractive(t *testing.T) {
+
+	config := &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := client("", "TACACS+ login", []string{"Password: ", "Token code: ", "RSA passcode: "}, []bool{false, true, false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 3 || answers[0] != "pass" || answers[1] != "123456" || answers[2] != "654321" {
+				return nil, fmt.Errorf("bad answers: %q", answers)
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"auth": "keyboard-interactive"}}, nil
+		},
+	}
+
+	// launch bogus test server
+	addr := ":2023"
+	s, listenErr := spawnServerSSH(t, addr, config)
+	if listenErr != nil {
+		t.Errorf("could not spawn bogus SSH server: %v", listenErr)
+	}
+
+	// run client test
+	logger := &testLogger{t}
+	tab := NewDeviceTable()
+	RegisterModels(logger, tab)
+	CreateDevice(tab, logger, "linux", "lab1", "localhost"+addr, "ssh", "lab", "pass", "", false, nil)
+
+	d, _ := tab.GetDevice("lab1")
+	d.Attr.SSHChallenges = []conf.PromptResponse{
+		{Pattern: `(?i)token`, Response: "123456"},
+		{Pattern: `(?i)rsa passcode`, Response: "654321"}, // configured pair wins over password-like prompt
+	}
+	tab.UpdateDevice(d)
+
+	r := fetchOne(t, tab, logger, "lab1")
+	if r.Code != fetchErrNone {
+		t.Errorf("keyboard-interactive: code=%d msg=%s", r.Code, r.Msg)
+	}
+	if r.Auth != authKeyboardInteractive {
+		t.Errorf("keyboard-interactive: auth=%s wanted=%s", r.Auth, authKeyboardInteractive)
+	}
+
+	// missing challenge answer must fail
+	d.Attr.SSHChallenges = nil
+	tab.UpdateDevice(d)
+
+	r = fetchOne(t, tab, logger, "lab1")
+	if r.Code != fetchErrTransp {
+		t.Errorf("keyboard-interactive unanswered: code=%d msg=%s", r.Code, r.Msg)
+	}
+
+	s.close() // shutdown server
+
+	<-s.done // wait termination of accept loop goroutine
+}
@@ -1,19 +1,38 @@
 package dev
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/udhos/jazigo/conf"
 )
 
 // DeviceTable is goroutine concurrency-safe list of devices.
 // Data is fully copied when either entering or leaving DeviceTable.
 // Data is not shared with pointers.
 type DeviceTable struct {
-	models  map[string]*Model  // label => model
-	devices map[string]*Device // id => device
-	lock    sync.RWMutex
+	models    map[string]*Model  // label => model
+	devices   map[string]*Device // id => device
+	modelDefs []conf.ModelConfig // declarative models from the config Models section
+	lock      sync.RWMutex
+
+	running     map[string]*runningFetch // id => fetch in progress
+	runningLock sync.Mutex
+}
+
+// runningFetch is a fetch in progress, registered by the Spawner.
+// The fetch is shared by the requests joined to it. It is cancelled only when every request
+// carrying its own context is cancelled, never while a request without context waits for it.
+type runningFetch struct {
+	cancel  context.CancelFunc
+	waiters []chan FetchResult // requests joined while in progress
+	holders int                // requests still wanting the fetch
+	stops   []func() bool      // unregister request context callbacks
+	done    bool               // set by fetchEnd
 }
 
 // DeviceUpdater is helper interface for a device store which can provide and update device information.
@@ -24,7 +43,7 @@ type DeviceUpdater interface {
 
 // NewDeviceTable creates a device table.
 func NewDeviceTable() *DeviceTable {
-	return &DeviceTable{models: map[string]*Model{}, devices: map[string]*Device{}, lock: sync.RWMutex{}}
+	return &DeviceTable{models: map[string]*Model{}, devices: map[string]*Device{}, lock: sync.RWMutex{}, running: map[string]*runningFetch{}}
 }
 
 // GetModel looks up a model in the device table.
@@ -57,6 +76,76 @@ func (t *DeviceTable) SetModel(m *Model, logger hasPrintf) error {
 	return nil
 }
 
+// ReplaceModels drops all declarative models, then adds the given ones.
+// Built-in models are never replaced.
+// Devices using a replaced model get the new model attributes, except the ones they override.
+// defs is the config Models section, kept for saving the config back.
+func (t *DeviceTable) ReplaceModels(models []*Model, defs []conf.ModelConfig, logger hasPrintf) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	old := map[string]*Model{}
+	for name, m := range t.models {
+		if m.source != "" {
+			old[name] = m
+			delete(t.models, name)
+		}
+	}
+
+	var errList []string
+	for _, m := range models {
+		if _, found := t.models[m.name]; found {
+			errList = append(errList, fmt.Sprintf("model '%s' from %s: already exists", m.name, m.source))
+			continue
+		}
+		logger.Printf("DeviceTable.ReplaceModels: registering model: '%s' from %s", m.name, m.source)
+		m1 := *m // force copy data
+		t.models[m1.name] = &m1
+	}
+
+	for _, d := range t.devices {
+		prev, replaced := old[d.devModel.name]
+		m, found := t.models[d.devModel.name]
+		if !replaced || !found || m.source == "" {
+			continue // model kept, dropped or shadowed by built-in
+		}
+		d.Attr = refreshModelAttr(prev.defaultAttr, m.defaultAttr, d.Attr)
+		m1 := *m // force copy data
+		d.devModel = &m1
+		logger.Printf("DeviceTable.ReplaceModels: device '%s': attributes refreshed from model '%s'", d.ID, m.name)
+	}
+
+	t.modelDefs = append([]conf.ModelConfig(nil), defs...)
+
+	if errList != nil {
+		return fmt.Errorf("DeviceTable.ReplaceModels: %s", strings.Join(errList, "; "))
+	}
+	return nil
+}
+
+// ModelDefs gets the config Models section.
+func (t *DeviceTable) ModelDefs() []conf.ModelConfig {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return append([]conf.ModelConfig(nil), t.modelDefs...)
+}
+
+// ModelSource informs where a model was defined: "built-in", a model file or the config section.
+func (t *DeviceTable) ModelSource(modelName string) string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	m, found := t.models[modelName]
+	if !found {
+		return ""
+	}
+	if m.source == "" {
+		return "built-in"
+	}
+	return m.source
+}
+
 // GetDevice finds a device in the device table.
 func (t *DeviceTable) GetDevice(id string) (*Device, error) {
 	t.lock.RLock()
@@ -173,3 +262,115 @@ func (t *DeviceTable) ListModels() []string {
 	}
 	return models
 }
+
+// fetchBegin registers a fetch in progress for device id, started by request req.
+// The returned token unregisters the fetch with fetchEnd.
+func (t *DeviceTable) fetchBegin(id string, cancel context.CancelFunc, req FetchRequest) *runningFetch {
+	t.runningLock.Lock()
+	defer t.runningLock.Unlock()
+
+	r := &runningFetch{cancel: cancel}
+	t.running[id] = r
+	t.fetchHold(id, r, req)
+	return r
+}
+
+// fetchJoin adds a request to the fetch in progress for device id, so it gets the same result.
+// It reports false if no fetch is running.
+func (t *DeviceTable) fetchJoin(id string, req FetchRequest) bool {
+	t.runningLock.Lock()
+	defer t.runningLock.Unlock()
+
+	r, found := t.running[id]
+	if !found {
+		return false
+	}
+	t.fetchHold(id, r, req)
+	return true
+}
+
+// fetchHold adds a request to a fetch in progress. A nil ReplyChan joins without waiting for the result.
+// A request without context holds the fetch until the end. Must be called under runningLock.
+func (t *DeviceTable) fetchHold(id string, r *runningFetch, req FetchRequest) {
+	if req.ReplyChan != nil {
+		r.waiters = append(r.waiters, req.ReplyChan)
+	}
+	r.holders++
+	if req.Ctx == nil {
+		return // held until the end
+	}
+	r.stops = append(r.stops, context.AfterFunc(req.Ctx, func() { t.fetchRelease(id, r, req.ReplyChan) }))
+}
+
+// fetchRelease drops a request whose context was cancelled.
+// The last request holding the fetch cancels it, then gets the aborted result from the fetch.
+// Otherwise the fetch goes on for the other requests, and the released request gets an aborted result at once.
+func (t *DeviceTable) fetchRelease(id string, r *runningFetch, replyChan chan FetchResult) {
+	t.runningLock.Lock()
+
+	if r.done {
+		t.runningLock.Unlock()
+		return // result already delivered
+	}
+
+	r.holders--
+	if r.holders < 1 {
+		r.cancel()
+		t.runningLock.Unlock()
+		return
+	}
+
+	if replyChan == nil {
+		t.runningLock.Unlock()
+		return
+	}
+	for i, w := range r.waiters {
+		if w == replyChan {
+			r.waiters = append(r.waiters[:i], r.waiters[i+1:]...)
+			break
+		}
+	}
+	t.runningLock.Unlock()
+
+	now := time.Now()
+	replyChan <- FetchResult{DevID: id, Msg: "aborted: request cancelled, fetch goes on for other requests", Code: fetchErrAbort, Begin: now, End: now}
+}
+
+// fetchEnd unregisters a fetch in progress and returns the requests joined to it.
+// A newer fetch registered for the same device is kept.
+func (t *DeviceTable) fetchEnd(id string, r *runningFetch) []chan FetchResult {
+	t.runningLock.Lock()
+	defer t.runningLock.Unlock()
+
+	if t.running[id] == r {
+		delete(t.running, id)
+	}
+	r.done = true
+	for _, stop := range r.stops {
+		stop()
+	}
+	return r.waiters
+}
+
+// FetchRunning reports whether a fetch is in progress for device id.
+func (t *DeviceTable) FetchRunning(id string) bool {
+	t.runningLock.Lock()
+	defer t.runningLock.Unlock()
+
+	_, found := t.running[id]
+	return found
+}
+
+// AbortFetch cancels the fetch in progress for device id.
+// It reports false if no fetch was running.
+func (t *DeviceTable) AbortFetch(id string) bool {
+	t.runningLock.Lock()
+	defer t.runningLock.Unlock()
+
+	r, found := t.running[id]
+	if !found {
+		return false
+	}
+	r.cancel()
+	return true
+}
@@ -1,24 +1,54 @@
 package dev
 
 import (
-	"time"
+	"bytes"
 )
 
+// telnet commands (RFC 854)
 const (
+	cmdSE   = 240
+	cmdSB   = 250
 	cmdWill = 251
 	cmdWont = 252
 	cmdDo   = 253
 	cmdDont = 254
 	cmdIAC  = 255
+)
+
+// telnet options
+const (
+	optBinary         = 0  // RFC 856
+	optEcho           = 1  // RFC 857
+	optSupressGoAhead = 3  // RFC 858
+	optTerminalType   = 24 // RFC 1091
+	optNAWS           = 31 // RFC 1073
+)
 
-	optEcho           = 1
-	optSupressGoAhead = 3
-	optLinemode       = 34
+// terminal type subnegotiation
+const (
+	ttypeIs   = 0
+	ttypeSend = 1
+)
+
+const (
+	telnetTerminalType = "XTERM"
+	telnetWindowWidth  = 200
+	telnetWindowHeight = 65000 // very tall window keeps devices from paging
 )
 
-func shift(b []byte, size, offset int) int {
-	copy(b, b[offset:size])
-	return size - offset
+// telnetLocalOptions are the options we agree to perform (reply WILL to DO).
+var telnetLocalOptions = map[byte]bool{
+	optBinary:         true,
+	optSupressGoAhead: true,
+	optTerminalType:   true,
+	optNAWS:           true,
+}
+
+// telnetRemoteOptions are the options we let the device perform (reply DO to WILL).
+var telnetRemoteOptions = map[byte]bool{
+	optBinary:         true,
+	optEcho:           true,
+	optSupressGoAhead: true,
 }
 
 type telnetNegotiationOnly struct{}
@@ -29,44 +59,167 @@ func (e telnetNegotiationOnly) Error() string {
 	return "telnetNegotiationOnlyError"
 }
 
-func telnetNegotiation(buf []byte, n int, t transp, logger hasPrintf, debug bool) (int, error) {
+// parser states
+const (
+	telnetStateData = iota
+	telnetStateCR   // got CR, NUL next is dropped
+	telnetStateIAC
+	telnetStateOption // got IAC WILL/WONT/DO/DONT, waiting option
+	telnetStateSB     // inside subnegotiation
+	telnetStateSBIAC  // got IAC inside subnegotiation
+)
+
+// telnetMaxSB limits subnegotiation buffering from misbehaving devices.
+const telnetMaxSB = 1024
+
+// telnetNegotiator is the telnet option state machine.
+// It keeps state between reads, so commands split across reads are handled.
+// Replies are answered only on state changes, which prevents negotiation loops (RFC 854).
+type telnetNegotiator struct {
+	state  int
+	verb   byte
+	sb     []byte
+	local  map[byte]bool // enabled options we perform
+	remote map[byte]bool // enabled options the device performs
+}
+
+func newTelnetNegotiator() *telnetNegotiator {
+	return &telnetNegotiator{local: map[byte]bool{}, remote: map[byte]bool{}}
+}
+
+// decode strips telnet commands from buf in place.
+// CR NUL, the telnet encoding of a bare CR (RFC 854), is reduced to CR so NUL never reaches captures.
+// It returns the size of remaining data and the bytes to send back to the device.
+func (tn *telnetNegotiator) decode(buf []byte) (int, []byte) {
+	var reply []byte
+	n := 0
+
+	for _, c := range buf {
+		switch tn.state {
+		case telnetStateCR:
+			tn.state = telnetStateData
+			if c == 0 {
+				continue // drop NUL after CR
+			}
+			fallthrough
+		case telnetStateData:
+			if c == cmdIAC {
+				tn.state = telnetStateIAC
+				continue
+			}
+			buf[n] = c
+			n++
+			if c == '\r' {
+				tn.state = telnetStateCR
+			}
+		case telnetStateIAC:
+			switch c {
+			case cmdIAC:
+				buf[n] = c // escaped 255 data byte
+				n++
+				tn.state = telnetStateData
+			case cmdWill, cmdWont, cmdDo, cmdDont:
+				tn.verb = c
+				tn.state = telnetStateOption
+			case cmdSB:
+				tn.sb = tn.sb[:0]
+				tn.state = telnetStateSB
+			default:
+				tn.state = telnetStateData // NOP, GA, AYT etc: ignore
+			}
+		case telnetStateOption:
+			reply = append(reply, tn.option(tn.verb, c)...)
+			tn.state = telnetStateData
+		case telnetStateSB:
+			if c == cmdIAC {
+				tn.state = telnetStateSBIAC
+				continue
+			}
+			if len(tn.sb) < telnetMaxSB {
+				tn.sb = append(tn.sb, c)
+			}
+		case telnetStateSBIAC:
+			switch c {
+			case cmdSE:
+				reply = append(reply, tn.subnegotiation(tn.sb)...)
+				tn.state = telnetStateData
+			case cmdIAC:
+				if len(tn.sb) < telnetMaxSB {
+					tn.sb = append(tn.sb, c)
+				}
+				tn.state = telnetStateSB
+			default:
+				tn.state = telnetStateSB // protocol violation: keep waiting SE
+			}
+		}
+	}
 
-	timeout := 5 * time.Second // FIXME??
-	hitNeg := false
+	return n, reply
+}
 
-	for {
-		if n < 3 {
-			break
+// option answers WILL/WONT/DO/DONT for opt.
+func (tn *telnetNegotiator) option(verb, opt byte) []byte {
+	switch verb {
+	case cmdDo:
+		if tn.local[opt] {
+			return nil // already enabled
+		}
+		if !telnetLocalOptions[opt] {
+			return []byte{cmdIAC, cmdWont, opt}
 		}
-		if buf[0] != cmdIAC {
-			break // not IAC
+		tn.local[opt] = true
+		reply := []byte{cmdIAC, cmdWill, opt}
+		if opt == optNAWS {
+			reply = append(reply, telnetWindowSize(telnetWindowWidth, telnetWindowHeight)...)
 		}
-		if debug {
-			logger.Printf("telnetNegotiation: debug: FOUND telnet IAC")
+		return reply
+	case cmdDont:
+		if !tn.local[opt] {
+			return nil // already disabled
 		}
-		b1 := buf[1]
-		switch b1 {
-		case cmdDo, cmdDont:
-			opt := buf[2]
-			t.SetWriteDeadline(time.Now().Add(timeout)) // FIXME: handle error
-			t.Write([]byte{cmdIAC, cmdWont, opt})       // IAC WONT opt - FIXME: handle error
-			n = shift(buf, n, 3)
-			hitNeg = true
-			continue
-		case cmdWill, cmdWont:
-			opt := buf[2]
-			t.SetWriteDeadline(time.Now().Add(timeout)) // FIXME: handle error
-			t.Write([]byte{cmdIAC, cmdDont, opt})       // IAC DONT opt - FIXME: handle error
-			n = shift(buf, n, 3)
-			hitNeg = true
-			continue
+		tn.local[opt] = false
+		return []byte{cmdIAC, cmdWont, opt}
+	case cmdWill:
+		if tn.remote[opt] {
+			return nil // already enabled
 		}
-		break
+		if !telnetRemoteOptions[opt] {
+			return []byte{cmdIAC, cmdDont, opt}
+		}
+		tn.remote[opt] = true
+		return []byte{cmdIAC, cmdDo, opt}
+	case cmdWont:
+		if !tn.remote[opt] {
+			return nil // already disabled
+		}
+		tn.remote[opt] = false
+		return []byte{cmdIAC, cmdDont, opt}
 	}
+	return nil
+}
 
-	if n == 0 && hitNeg {
-		return 0, telnetNegOnly
+// subnegotiation answers IAC SB ... IAC SE.
+func (tn *telnetNegotiator) subnegotiation(sb []byte) []byte {
+	if len(sb) == 2 && sb[0] == optTerminalType && sb[1] == ttypeSend && tn.local[optTerminalType] {
+		reply := []byte{cmdIAC, cmdSB, optTerminalType, ttypeIs}
+		reply = append(reply, telnetTerminalType...)
+		return append(reply, cmdIAC, cmdSE)
 	}
+	return nil
+}
 
-	return n, nil
+// telnetWindowSize builds IAC SB NAWS width height IAC SE.
+func telnetWindowSize(width, height uint16) []byte {
+	size := []byte{byte(width >> 8), byte(width), byte(height >> 8), byte(height)}
+	reply := []byte{cmdIAC, cmdSB, optNAWS}
+	reply = append(reply, telnetEscape(size)...)
+	return append(reply, cmdIAC, cmdSE)
+}
+
+// telnetEscape doubles IAC bytes.
+func telnetEscape(b []byte) []byte {
+	if bytes.IndexByte(b, cmdIAC) < 0 {
+		return b
+	}
+	return bytes.ReplaceAll(b, []byte{cmdIAC}, []byte{cmdIAC, cmdIAC})
 }
@@ -0,0 +1,146 @@
+package dev
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+// recorded device negotiation streams, split as received from the wire
+var telnetRecordings = []struct {
+	name  string
+	reads []string
+	data  string
+	reply string
+}{
+	{
+		name:  "plain data",
+		reads: []string{"\r\nUsername: "},
+		data:  "\r\nUsername: ",
+	},
+	{
+		name:  "cisco ios",
+		reads: []string{"\xff\xfb\x01\xff\xfb\x03\xff\xfd\x18\xff\xfd\x1f\r\n\r\nUser Access Verification\r\n\r\nUsername: "},
+		data:  "\r\n\r\nUser Access Verification\r\n\r\nUsername: ",
+		reply: "\xff\xfd\x01\xff\xfd\x03\xff\xfb\x18\xff\xfb\x1f\xff\xfa\x1f\x00\xc8\xfd\xe8\xff\xf0",
+	},
+	{
+		name:  "terminal type subnegotiation",
+		reads: []string{"\xff\xfd\x18", "\xff\xfa\x18\x01\xff\xf0login: "},
+		data:  "login: ",
+		reply: "\xff\xfb\x18\xff\xfa\x18\x00XTERM\xff\xf0",
+	},
+	{
+		name:  "terminal type send before do is ignored",
+		reads: []string{"\xff\xfa\x18\x01\xff\xf0login: "},
+		data:  "login: ",
+	},
+	{
+		name:  "unknown and refused options",
+		reads: []string{"\xff\xfd\x22\xff\xfb\x22\xff\xfd\x05\xff\xfb\x27> "},
+		data:  "> ",
+		reply: "\xff\xfc\x22\xff\xfe\x22\xff\xfc\x05\xff\xfe\x27",
+	},
+	{
+		name:  "no reply loop on repeated or disabled options",
+		reads: []string{"\xff\xfb\x01\xff\xfb\x01\xff\xfe\x01\xff\xfc\x03\xff\xfd\x00\xff\xfd\x00"},
+		reply: "\xff\xfd\x01\xff\xfb\x00",
+	},
+	{
+		name:  "disable enabled options",
+		reads: []string{"\xff\xfb\x03\xff\xfd\x00", "\xff\xfc\x03\xff\xfe\x00#"},
+		data:  "#",
+		reply: "\xff\xfd\x03\xff\xfb\x00\xff\xfe\x03\xff\xfc\x00",
+	},
+	{
+		name:  "command split across reads",
+		reads: []string{"abc\xff", "\xfb", "\x01def\xff\xfa\x18", "\x01\xff", "\xf0ghi"},
+		data:  "abcdefghi",
+		reply: "\xff\xfd\x01",
+	},
+	{
+		name:  "escaped iac and ignored commands",
+		reads: []string{"a\xff\xffb\xff\xf1c\xff\xf9d"},
+		data:  "a\xffbcd",
+	},
+	{
+		name:  "cr nul",
+		reads: []string{"a\r\x00b\r", "\x00c\r\n\x00d\r\r\x00"},
+		data:  "a\rb\rc\r\n\x00d\r\r",
+	},
+	{
+		name:  "unknown subnegotiation",
+		reads: []string{"\xff\xfa\x20\x01\xff\xff\x02\xff\xf0ok"},
+		data:  "ok",
+	},
+}
+
+func TestTelnetNegotiation(t *testing.T) {
+	for _, rec := range telnetRecordings {
+		tn := newTelnetNegotiator()
+		var data, reply []byte
+		for _, r := range rec.reads {
+			buf := []byte(r)
+			n, rep := tn.decode(buf)
+			data = append(data, buf[:n]...)
+			reply = append(reply, rep...)
+		}
+		if string(data) != rec.data {
+			t.Errorf("%s: data: got=%q want=%q", rec.name, data, rec.data)
+		}
+		if string(reply) != rec.reply {
+			t.Errorf("%s: reply: got=%q want=%q", rec.name, reply, rec.reply)
+		}
+	}
+}
+
+func TestTelnetWindowSize(t *testing.T) {
+	got := telnetWindowSize(255, 0xffff)
+	want := []byte("\xff\xfa\x1f\x00\xff\xff\xff\xff\xff\xff\xff\xf0")
+	if !bytes.Equal(got, want) {
+		t.Errorf("window size escaping: got=%q want=%q", got, want)
+	}
+}
+
+func TestTelnetTransport(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	s := &transpTelnet{Conn: client, logger: &testLogger{t}, neg: newTelnetNegotiator()}
+
+	go func() {
+		server.Write([]byte("\xff\xfd\x27"))
+		server.Write([]byte("Password: "))
+	}()
+
+	buf := make([]byte, 100)
+
+	// negotiation only: reply is sent from within Read
+	replyCh := make(chan []byte)
+	go func() {
+		reply := make([]byte, 3)
+		io.ReadFull(server, reply)
+		replyCh <- reply
+	}()
+	if _, err := s.Read(buf); err != telnetNegOnly {
+		t.Errorf("negotiation only read: got err=%v", err)
+	}
+	if reply := <-replyCh; string(reply) != "\xff\xfc\x27" {
+		t.Errorf("negotiation reply: got=%q", reply)
+	}
+
+	n, err := s.Read(buf)
+	if err != nil || string(buf[:n]) != "Password: " {
+		t.Errorf("data read: got=%q err=%v", buf[:n], err)
+	}
+
+	// password holding IAC must be escaped
+	go s.Write([]byte("p\xffss\n"))
+	sent := make([]byte, 6)
+	io.ReadFull(server, sent)
+	if string(sent) != "p\xff\xffss\n" {
+		t.Errorf("write escaping: got=%q", sent)
+	}
+}
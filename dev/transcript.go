@@ -0,0 +1,200 @@
+package dev
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/udhos/jazigo/conf"
+)
+
+const transcriptSuffix = ".transcript"
+const transcriptMask = "********"
+
+// TranscriptRoot builds the directory holding session transcripts, next to the errlogs.
+func TranscriptRoot(pathPrefix string) string {
+	return filepath.Join(filepath.Dir(pathPrefix), "transcript")
+}
+
+// TranscriptDir builds the directory holding session transcripts for a device.
+func TranscriptDir(pathPrefix, id string) string {
+	return filepath.Join(TranscriptRoot(pathPrefix), id)
+}
+
+// ListTranscripts lists transcript file names for a device, newest first.
+func ListTranscripts(pathPrefix, id string) ([]string, error) {
+	dir := TranscriptDir(pathPrefix, id)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ListTranscripts: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), transcriptSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names))) // names are timestamps
+	return names, nil
+}
+
+// transpRecorder records the session byte stream in both directions.
+// Each chunk goes on its own line: elapsed seconds, direction (> sent, < received) and quoted bytes.
+// The embedded transp is nil until an interactive transport is attached: fetches failing before it
+// and non-interactive backups (file transfer, NETCONF, HTTP) record only the header and the result.
+type transpRecorder struct {
+	transp
+	file    *os.File
+	writer  *bufio.Writer
+	begin   time.Time
+	secrets [][]byte
+	mutex   sync.Mutex
+}
+
+// openTranscript starts a new transcript for the device and prunes old ones, keeping the last d.Attr.TranscriptCount.
+func (d *Device) openTranscript(logger hasPrintf, logPathPrefix string, begin time.Time) (*transpRecorder, error) {
+	dir := TranscriptDir(logPathPrefix, d.ID)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("openTranscript: %v", err)
+	}
+
+	name := begin.UTC().Format("20060102-150405.000000000") + transcriptSuffix
+	path := filepath.Join(dir, name)
+
+	f, createErr := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if createErr != nil {
+		return nil, fmt.Errorf("openTranscript: %v", createErr)
+	}
+
+	r := &transpRecorder{file: f, writer: bufio.NewWriter(f), begin: begin, secrets: d.transcriptSecrets()}
+
+	fmt.Fprintf(r.writer, "# begin=%s model=%s dev=%s host=%s transport=%s\n",
+		begin.String(), d.devModel.name, d.ID, d.HostPort, d.Transports)
+
+	pruneTranscripts(logger, logPathPrefix, d.ID, d.Attr.TranscriptCount)
+
+	return r, nil
+}
+
+// transcriptSecrets lists the configured secrets, longest first: login and enable passwords,
+// ssh challenge responses and chat responses to password-like prompts.
+func (d *Device) transcriptSecrets() [][]byte {
+	list := []string{d.LoginPassword, d.EnablePassword}
+	for _, c := range d.Attr.SSHChallenges {
+		list = append(list, c.Response)
+	}
+	chat := func(steps []conf.ChatStep) {
+		for _, step := range steps {
+			if passwordChallenge.MatchString(step.Expect) {
+				list = append(list, strings.TrimRight(step.Send, "\r\n"))
+			}
+		}
+	}
+	chat(d.Attr.ChatScript)
+	for _, c := range d.Attr.CommandChats {
+		chat(c.Steps)
+	}
+
+	var secrets [][]byte
+	for _, s := range list {
+		if s != "" && !slices.ContainsFunc(secrets, func(b []byte) bool { return string(b) == s }) {
+			secrets = append(secrets, []byte(s))
+		}
+	}
+	sort.SliceStable(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	return secrets
+}
+
+func pruneTranscripts(logger hasPrintf, logPathPrefix, id string, keep int) {
+	names, listErr := ListTranscripts(logPathPrefix, id)
+	if listErr != nil {
+		logger.Printf("pruneTranscripts: %s: %v", id, listErr)
+		return
+	}
+	if len(names) <= keep {
+		return
+	}
+	dir := TranscriptDir(logPathPrefix, id)
+	for _, name := range names[keep:] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			logger.Printf("pruneTranscripts: %s: %v", id, err)
+		}
+	}
+}
+
+// attach starts recording the interactive session over transport t.
+func (r *transpRecorder) attach(t transp, transport string) {
+	r.transp = t
+	r.note("transport open: %s", transport)
+}
+
+func (r *transpRecorder) record(dir string, b []byte) {
+	r.mutex.Lock()
+	fmt.Fprintf(r.writer, "%.6f %s %q\n", time.Since(r.begin).Seconds(), dir, b)
+	r.mutex.Unlock()
+}
+
+func (r *transpRecorder) note(format string, v ...interface{}) {
+	r.mutex.Lock()
+	fmt.Fprintf(r.writer, "%.6f # %s\n", time.Since(r.begin).Seconds(), fmt.Sprintf(format, v...))
+	r.mutex.Unlock()
+}
+
+func (r *transpRecorder) Read(b []byte) (int, error) {
+	n, err := r.transp.Read(b)
+	if n > 0 {
+		r.record("<", b[:n])
+	}
+	if err != nil && err != telnetNegOnly {
+		r.note("read: %v", err)
+	}
+	return n, err
+}
+
+// mask hides every secret found anywhere in sent data.
+// Longer secrets go first, so a secret holding a shorter one is hidden whole.
+func (r *transpRecorder) mask(b []byte) []byte {
+	for _, s := range r.secrets {
+		b = bytes.ReplaceAll(b, s, []byte(transcriptMask))
+	}
+	return b
+}
+
+func (r *transpRecorder) Write(b []byte) (int, error) {
+	n, err := r.transp.Write(b)
+	r.record(">", r.mask(b))
+	if err != nil {
+		r.note("write: %v", err)
+	}
+	return n, err
+}
+
+// finish appends the fetch result and closes the transcript file.
+// The transport is left open.
+func (r *transpRecorder) finish(logger hasPrintf, result FetchResult) {
+	if r.transp == nil {
+		r.note("no session recorded: transport not open or not interactive")
+	}
+	r.note("end success=%v code=%d transport=%s elapsed=%s message=[%s]",
+		result.Code == fetchErrNone, result.Code, result.Transport, time.Since(r.begin), result.Msg)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.writer.Flush(); err != nil {
+		logger.Printf("transcript: flush: %s: %v", r.file.Name(), err)
+	}
+	if err := r.file.Close(); err != nil {
+		logger.Printf("transcript: close: %s: %v", r.file.Name(), err)
+	}
+}
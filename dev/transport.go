@@ -1,6 +1,7 @@
 package dev
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -28,28 +30,47 @@ type transpTCP struct {
 type transpTelnet struct {
 	net.Conn
 	logger hasPrintf
+	neg    *telnetNegotiator
 }
 
-type telnetOptions struct {
-	supressGoAhead bool
-	linemode       bool
-}
-
+// Read strips telnet commands and answers option negotiation.
+// Replies are covered by the deadline set for the read.
 func (s *transpTelnet) Read(b []byte) (int, error) {
 	n1, err1 := s.Conn.Read(b)
+	n2, reply := s.neg.decode(b[:n1])
+	if len(reply) > 0 {
+		if _, wrErr := s.Conn.Write(reply); wrErr != nil {
+			return n2, fmt.Errorf("transpTelnet: negotiation reply: %v", wrErr)
+		}
+	}
 	if err1 != nil {
-		return n1, err1
+		return n2, err1
+	}
+	if n2 == 0 {
+		return 0, telnetNegOnly
+	}
+	return n2, nil
+}
+
+// Write escapes IAC bytes in data.
+func (s *transpTelnet) Write(b []byte) (int, error) {
+	if _, err := s.Conn.Write(telnetEscape(b)); err != nil {
+		return 0, err
 	}
-	n2, err2 := telnetNegotiation(b, n1, s, s.logger, false)
-	return n2, err2
+	return len(b), nil
 }
 
 type transpPipe struct {
 	proc     *exec.Cmd
 	stdout   io.ReadCloser
-	stderr   io.ReadCloser
-	reader   io.Reader
+	stderr   *pipeStderr
 	writer   io.WriteCloser
+	passFile string // temporary credentials file, removed on Close
+	scratch  string // temporary working directory, removed on Close
+	maxOut   int64  // max stdout size, 0 means unlimited
+	outSize  int64
+	waited   bool
+	waitErr  error
 	logger   hasPrintf
 	devLabel string
 	debug    bool
@@ -57,23 +78,68 @@ type transpPipe struct {
 	cancel   context.CancelFunc
 }
 
+// pipeStderrMax limits the amount of stderr kept from the external program.
+const pipeStderrMax = 16384
+
+// pipeStderr collects the external program stderr apart from stdout.
+type pipeStderr struct {
+	mutex     sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (e *pipeStderr) Write(b []byte) (int, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if room := pipeStderrMax - e.buf.Len(); len(b) > room {
+		e.buf.Write(b[:room])
+		e.truncated = true
+	} else {
+		e.buf.Write(b)
+	}
+	return len(b), nil // never block the program
+}
+
+func (e *pipeStderr) String() string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.truncated {
+		return e.buf.String() + "[...]"
+	}
+	return e.buf.String()
+}
+
 func (s *transpPipe) result(n int, err error) (int, error) {
 	var waitErr error
 	if err != nil {
-		waitErr = s.proc.Wait()
+		waitErr = s.wait()
 	}
 
 	ctxErr := s.ctx.Err()
 
 	if ctxErr != nil || waitErr != nil {
-		return n, fmt.Errorf("transPipe result error: error=[%v] context=[%v] wait=[%v]", err, ctxErr, waitErr)
+		return n, fmt.Errorf("transPipe result error: error=[%v] context=[%v] wait=[%v] stderr=[%s]", err, ctxErr, waitErr, s.stderr)
 	}
 
 	return n, err
 }
 
+// wait reaps the program once, keeping its exit status.
+func (s *transpPipe) wait() error {
+	if !s.waited {
+		s.waited = true
+		s.waitErr = s.proc.Wait()
+	}
+	return s.waitErr
+}
+
 func (s *transpPipe) Read(b []byte) (int, error) {
-	n, err := s.reader.Read(b)
+	n, err := s.stdout.Read(b)
+	s.outSize += int64(n)
+	if s.maxOut > 0 && s.outSize > s.maxOut {
+		s.cancel()
+		return 0, fmt.Errorf("transpPipe: %s - output exceeded limit of %d bytes", s.devLabel, s.maxOut)
+	}
 	return s.result(n, err)
 }
 
@@ -100,12 +166,27 @@ func (s *transpPipe) Close() error {
 
 	s.cancel()
 
+	if s.proc.Process != nil {
+		s.wait() // kill and reap
+	}
+
+	if stderr := s.stderr.String(); stderr != "" {
+		s.logger.Printf("transpPipe.Close: %s stderr: %q", s.devLabel, stderr)
+	}
+
 	err1 := s.stdout.Close()
-	err2 := s.stderr.Close()
-	err3 := s.writer.Close()
+	err2 := s.writer.Close()
+
+	var err3, err4 error
+	if s.passFile != "" {
+		err3 = os.Remove(s.passFile)
+	}
+	if s.scratch != "" {
+		err4 = os.RemoveAll(s.scratch)
+	}
 
-	if err1 != nil || err2 != nil || err3 != nil {
-		return fmt.Errorf("transpPipe: close error: out=[%v] err=[%v] writer=[%v]", err1, err2, err3)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return fmt.Errorf("transpPipe: close error: out=[%v] writer=[%v] passfile=[%v] scratch=[%v]", err1, err2, err3, err4)
 	}
 
 	return nil
@@ -113,6 +194,7 @@ func (s *transpPipe) Close() error {
 
 type transpSSH struct {
 	devLabel string
+	auth     string // auth method that succeeded
 	conn     net.Conn
 	client   *ssh.Client
 	session  *ssh.Session
@@ -151,20 +233,63 @@ func (s *transpSSH) Close() error {
 	return nil
 }
 
-func openTransportPipe(logger hasPrintf, modelName, devID, hostPort, transports, user, pass string, args []string, debug bool, timeout time.Duration) (transp, string, bool, error) {
-	s, err := openPipe(logger, modelName, devID, hostPort, transports, user, pass, args, debug, timeout)
+func openTransportPipe(ctx context.Context, logger hasPrintf, modelName, devID, hostPort, transports, user, pass string, args []string, debug bool, opt pipeOptions) (transp, string, bool, error) {
+	s, err := openPipe(ctx, logger, modelName, devID, hostPort, transports, user, pass, args, debug, opt)
 	return s, "pipe", true, err
 }
 
-func openPipe(logger hasPrintf, modelName, devID, hostPort, transports, user, pass string, args []string, debug bool, timeout time.Duration) (transp, error) {
+// openPipe runs the external program with its own environment.
+// The process environment is never touched, since concurrent devices would see each other's credentials.
+// The password is handed over according to opt.credentials:
+// "env" (default): JAZIGO_DEV_PASS variable
+// "stdin": first line of stdin
+// "file": JAZIGO_DEV_PASS_FILE variable points to a temporary 0600 file holding the password
+func openPipe(ctx context.Context, logger hasPrintf, modelName, devID, hostPort, transports, user, pass string, args []string, debug bool, opt pipeOptions) (transp, error) {
 
 	devLabel := fmt.Sprintf("%s %s %s", modelName, devID, hostPort)
 
 	logger.Printf("openPipe: %s - opening", devLabel)
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	switch opt.credentials {
+	case "", "env", "stdin", "file":
+	default:
+		return nil, fmt.Errorf("openPipe: %s - unknown credentials mode: '%s'", devLabel, opt.credentials)
+	}
+
+	uid, gid, idErr := lookupRunIDs(opt.runUser, opt.runGroup)
+	if idErr != nil {
+		return nil, fmt.Errorf("openPipe: %s - %v", devLabel, idErr)
+	}
+
+	sysAttr, sysErr := pipeSysProcAttr(uid, gid)
+	if sysErr != nil {
+		return nil, fmt.Errorf("openPipe: %s - %v", devLabel, sysErr)
+	}
+
+	gate, gateErr := newPipeLimitGate(opt.cpuLimit, opt.memoryLimit)
+	if gateErr != nil {
+		return nil, fmt.Errorf("openPipe: %s - %v", devLabel, gateErr)
+	}
+	if gate != nil {
+		defer gate.close()
+		args = gate.wrap(args)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opt.timeout)
 
 	c := exec.CommandContext(ctx, args[0], args[1:]...)
+	c.SysProcAttr = sysAttr
+	if gate != nil {
+		c.ExtraFiles = []*os.File{gate.r} // fd 3
+	}
+
+	c.WaitDelay = time.Second // do not hang on stderr held open by orphan children
+
+	c.Env = append(os.Environ(),
+		"JAZIGO_DEV_ID="+devID,
+		"JAZIGO_DEV_HOSTPORT="+hostPort,
+		"JAZIGO_DEV_USER="+user,
+	)
 
 	pipeOut, outErr := c.StdoutPipe()
 	if outErr != nil {
@@ -172,45 +297,87 @@ func openPipe(logger hasPrintf, modelName, devID, hostPort, transports, user, pa
 		return nil, fmt.Errorf("openPipe: StdoutPipe: %s - %v", devLabel, outErr)
 	}
 
-	pipeErr, errErr := c.StderrPipe()
-	if errErr != nil {
-		cancel()
-		return nil, fmt.Errorf("openPipe: StderrPipe: %s - %v", devLabel, errErr)
-	}
-
 	writer, wrErr := c.StdinPipe()
 	if wrErr != nil {
 		cancel()
 		return nil, fmt.Errorf("openPipe: StdinPipe: %s - %v", devLabel, wrErr)
 	}
 
-	s := &transpPipe{proc: c, logger: logger, devLabel: devLabel, debug: debug}
-	s.reader = io.MultiReader(pipeOut, pipeErr)
+	s := &transpPipe{proc: c, logger: logger, devLabel: devLabel, debug: debug, maxOut: opt.maxOutputSize}
 	s.stdout = pipeOut
-	s.stderr = pipeErr
+	s.stderr = &pipeStderr{}
 	s.writer = writer
 	s.ctx = ctx
 	s.cancel = cancel
 
-	logger.Printf("openPipe: %s - starting", devLabel)
+	c.Stderr = s.stderr
+
+	if opt.scratchDir {
+		dir, dirErr := os.MkdirTemp("", "jazigo-run-")
+		if dirErr != nil {
+			s.Close()
+			return nil, fmt.Errorf("openPipe: %s - scratch dir: %v", devLabel, dirErr)
+		}
+		s.scratch = dir
+		c.Dir = dir
+		if chownErr := chownRunIDs(dir, uid, gid); chownErr != nil {
+			s.Close()
+			return nil, fmt.Errorf("openPipe: %s - scratch dir: %v", devLabel, chownErr)
+		}
+	}
 
-	os.Setenv("JAZIGO_DEV_ID", devID)
-	os.Setenv("JAZIGO_DEV_HOSTPORT", hostPort)
-	os.Setenv("JAZIGO_DEV_USER", user)
-	os.Setenv("JAZIGO_DEV_PASS", pass)
+	switch opt.credentials {
+	case "", "env":
+		c.Env = append(c.Env, "JAZIGO_DEV_PASS="+pass)
+	case "file":
+		f, tmpErr := os.CreateTemp("", "jazigo-pass-") // mode 0600
+		if tmpErr != nil {
+			s.Close()
+			return nil, fmt.Errorf("openPipe: %s - credentials file: %v", devLabel, tmpErr)
+		}
+		s.passFile = f.Name()
+		_, wrErr := f.WriteString(pass)
+		if closeErr := f.Close(); wrErr == nil {
+			wrErr = closeErr
+		}
+		if wrErr == nil {
+			wrErr = chownRunIDs(s.passFile, uid, gid) // readable by run user only
+		}
+		if wrErr != nil {
+			s.Close()
+			return nil, fmt.Errorf("openPipe: %s - credentials file: %v", devLabel, wrErr)
+		}
+		c.Env = append(c.Env, "JAZIGO_DEV_PASS_FILE="+s.passFile)
+	}
+
+	logger.Printf("openPipe: %s - starting", devLabel)
 
 	if startErr := s.proc.Start(); startErr != nil {
 		s.Close()
 		return nil, fmt.Errorf("openPipe: error: %v", startErr)
 	}
 
+	if gate != nil {
+		if limitErr := gate.release(c.Process.Pid); limitErr != nil {
+			s.Close()
+			return nil, fmt.Errorf("openPipe: %s - %v", devLabel, limitErr)
+		}
+	}
+
+	if opt.credentials == "stdin" {
+		if _, err := io.WriteString(s.writer, pass+"\n"); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("openPipe: %s - credentials stdin: %v", devLabel, err)
+		}
+	}
+
 	logger.Printf("openPipe: %s - started", devLabel)
 
 	return s, nil
 }
 
-func openTransport(logger hasPrintf, modelName, devID, hostPort, transports, user, pass string,
-	sshClearCiphers bool, sshAddCiphers []string) (transp, string, bool, error) {
+func openTransport(logger hasPrintf, modelName, devID, hostPort, transports string, dl *dialer, auth *sshAuthOptions,
+	sshClearCiphers bool, sshAddCiphers []string, hostKeyCallback ssh.HostKeyCallback) (transp, string, bool, error) {
 	tList := strings.Split(transports, ",")
 	if len(tList) < 1 {
 		return nil, transports, false, fmt.Errorf("openTransport: missing transports: [%s]", transports)
@@ -218,14 +385,12 @@ func openTransport(logger hasPrintf, modelName, devID, hostPort, transports, use
 
 	var lastErr error
 
-	timeout := 10 * time.Second
-
 	for _, t := range tList {
 		switch t {
 		case "ssh":
 			hp := forceHostPort(hostPort, "22")
-			s, err := openSSH(logger, modelName, devID, hp, timeout, user, pass,
-				sshClearCiphers, sshAddCiphers)
+			s, err := openSSH(logger, modelName, devID, hp, dl, auth,
+				sshClearCiphers, sshAddCiphers, hostKeyCallback)
 			if err == nil {
 				return s, t, true, nil
 			}
@@ -233,14 +398,14 @@ func openTransport(logger hasPrintf, modelName, devID, hostPort, transports, use
 			lastErr = err
 		case "telnet":
 			hp := forceHostPort(hostPort, "23")
-			s, err := openTelnet(logger, modelName, devID, hp, timeout)
+			s, err := openTelnet(logger, modelName, devID, hp, dl)
 			if err == nil {
 				return s, t, false, nil
 			}
 			logger.Printf("openTransport: %v", err)
 			lastErr = err
 		default:
-			s, err := openTCP(logger, modelName, devID, hostPort, timeout)
+			s, err := openTCP(logger, modelName, devID, hostPort, dl)
 			if err == nil {
 				return s, t, false, nil
 			}
@@ -249,57 +414,20 @@ func openTransport(logger hasPrintf, modelName, devID, hostPort, transports, use
 		}
 	}
 
-	return nil, transports, false, fmt.Errorf("openTransport: %s %s %s %s - unable to open transport: last error: %v", modelName, devID, hostPort, transports, lastErr)
+	return nil, transports, false, fmt.Errorf("openTransport: %s %s %s %s - unable to open transport: last error: %w", modelName, devID, hostPort, transports, lastErr)
 }
 
-func forceHostPort(hostPort, defaultPort string) string {
-	i := strings.Index(hostPort, ":")
-	if i < 0 {
-		return fmt.Sprintf("%s:%s", hostPort, defaultPort)
-	}
-	return hostPort
-}
-
-func hostKeyCheck(hostname string, remote net.Addr, key ssh.PublicKey) error {
-	return nil // FIXME hostKeyCheck accept anything
-}
-
-func openSSH(logger hasPrintf, modelName, devID, hostPort string, timeout time.Duration, user, pass string,
-	sshClearCiphers bool, sshAddCiphers []string) (transp, error) {
+func openSSH(logger hasPrintf, modelName, devID, hostPort string, dl *dialer, auth *sshAuthOptions,
+	sshClearCiphers bool, sshAddCiphers []string, hostKeyCallback ssh.HostKeyCallback) (transp, error) {
 
-	conn, dialErr := net.DialTimeout("tcp", hostPort, timeout)
-	if dialErr != nil {
-		return nil, fmt.Errorf("openSSH: Dial: %s %s %s - %v", modelName, devID, hostPort, dialErr)
+	s, clientErr := openSSHClient(logger, modelName, devID, hostPort, dl, auth, sshClearCiphers, sshAddCiphers, hostKeyCallback)
+	if clientErr != nil {
+		return nil, clientErr
 	}
 
-	conf := &ssh.Config{}
-	conf.SetDefaults()
-	if sshClearCiphers {
-		conf.Ciphers = nil
-	}
-	conf.Ciphers = append(conf.Ciphers, sshAddCiphers...)
-
-	config := &ssh.ClientConfig{
-		Config: *conf,
-		User:   user,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(pass),
-		},
-		Timeout:         timeout,
-		HostKeyCallback: hostKeyCheck,
-	}
-
-	c, chans, reqs, connErr := ssh.NewClientConn(conn, hostPort, config)
-	if connErr != nil {
-		return nil, fmt.Errorf("openSSH: NewClientConn: %s %s %s - %v", modelName, devID, hostPort, connErr)
-	}
-
-	cli := ssh.NewClient(c, chans, reqs)
-
-	s := &transpSSH{conn: conn, client: cli, devLabel: fmt.Sprintf("%s %s %s", modelName, devID, hostPort) /*, logger: logger*/}
-
 	ses, sessionErr := s.client.NewSession()
 	if sessionErr != nil {
+		s.conn.Close()
 		return nil, fmt.Errorf("openSSH: NewSession: %s - %v", s.devLabel, sessionErr)
 	}
 
@@ -339,21 +467,65 @@ func openSSH(logger hasPrintf, modelName, devID, hostPort string, timeout time.D
 	return s, nil
 }
 
-func openTelnet(logger hasPrintf, modelName, devID, hostPort string, timeout time.Duration) (transp, error) {
+// openSSHClient establishes an authenticated SSH connection.
+func openSSHClient(logger hasPrintf, modelName, devID, hostPort string, dl *dialer, auth *sshAuthOptions,
+	sshClearCiphers bool, sshAddCiphers []string, hostKeyCallback ssh.HostKeyCallback) (*transpSSH, error) {
+
+	conn, dialErr := dl.dial(hostPort)
+	if dialErr != nil {
+		return nil, fmt.Errorf("openSSH: Dial: %s %s %s - %w", modelName, devID, hostPort, dialErr)
+	}
+
+	conf := &ssh.Config{}
+	conf.SetDefaults()
+	if sshClearCiphers {
+		conf.Ciphers = nil
+	}
+	conf.Ciphers = append(conf.Ciphers, sshAddCiphers...)
+
+	devLabel := fmt.Sprintf("%s %s %s", modelName, devID, hostPort)
+
+	methods, tracker := sshAuthMethods(logger, devLabel, auth)
+	defer tracker.close()
+
+	config := &ssh.ClientConfig{
+		Config:          *conf,
+		User:            auth.user,
+		Auth:            methods,
+		Timeout:         dl.timeout,
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	c, chans, reqs, connErr := ssh.NewClientConn(conn, hostPort, config)
+	if connErr != nil {
+		conn.Close()
+		return nil, fmt.Errorf("openSSH: NewClientConn: %s %s %s - %w", modelName, devID, hostPort, connErr)
+	}
+
+	cli := ssh.NewClient(c, chans, reqs)
+
+	s := &transpSSH{conn: conn, client: cli, devLabel: devLabel, auth: tracker.last}
+
+	logger.Printf("openSSH: %s - authenticated: method=%s", devLabel, s.auth)
+
+	return s, nil
+}
+
+func openTelnet(logger hasPrintf, modelName, devID, hostPort string, dl *dialer) (transp, error) {
 
-	conn, err := net.DialTimeout("tcp", hostPort, timeout)
+	conn, err := dl.dial(hostPort)
 	if err != nil {
-		return nil, fmt.Errorf("openTelnet: %s %s %s - %v", modelName, devID, hostPort, err)
+		return nil, fmt.Errorf("openTelnet: %s %s %s - %w", modelName, devID, hostPort, err)
 	}
 
-	return &transpTelnet{conn, logger}, nil
+	return &transpTelnet{Conn: conn, logger: logger, neg: newTelnetNegotiator()}, nil
 }
 
-func openTCP(logger hasPrintf, modelName, devID, hostPort string, timeout time.Duration) (transp, error) {
+func openTCP(logger hasPrintf, modelName, devID, hostPort string, dl *dialer) (transp, error) {
 
-	conn, err := net.DialTimeout("tcp", hostPort, timeout)
+	conn, err := dl.dial(hostPort)
 	if err != nil {
-		return nil, fmt.Errorf("openTCP: %s %s %s - %v", modelName, devID, hostPort, err)
+		return nil, fmt.Errorf("openTCP: %s %s %s - %w", modelName, devID, hostPort, err)
 	}
 
 	return &transpTCP{conn}, nil
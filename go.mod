@@ -4,10 +4,12 @@ require (
 	github.com/aws/aws-sdk-go-v2/config v1.29.17
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.83.0
 	github.com/icza/gowut v1.4.0
+	github.com/pkg/sftp v1.13.9
 	github.com/udhos/difflib v0.0.0-20170223180222-9237ff6aafff
 	github.com/udhos/equalfile v0.3.0
 	github.com/udhos/lockfile v0.0.0-20160928001432-1d49c987357a
 	golang.org/x/crypto v0.39.0
+	golang.org/x/sys v0.33.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -28,7 +30,7 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/ssooidc v1.30.3 // indirect
 	github.com/aws/aws-sdk-go-v2/service/sts v1.34.0 // indirect
 	github.com/aws/smithy-go v1.22.4 // indirect
-	golang.org/x/sys v0.33.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 )
 
 go 1.23.0
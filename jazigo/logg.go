@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/udhos/jazigo/store"
@@ -18,6 +19,8 @@ type Logfile struct {
 	lastSizeCheck     time.Time
 	output            *os.File
 	logger            *log.Logger
+	closed            bool
+	lock              sync.Mutex
 }
 
 // NewLogfile creates a new log stream capable of automatically saving to filesystem.
@@ -63,7 +66,7 @@ func (l *Logfile) rotate() {
 		l.output = nil
 	}
 
-	outputPath, newErr := store.SaveNewConfig(l.logPathPrefix, l.maxFiles, l.logger, touchFunc, false, "")
+	outputPath, newErr := store.SaveNewConfig(l.logPathPrefix, l.maxFiles, l.logger, touchFunc, false, "", nil)
 	if newErr != nil {
 		if l.output != nil {
 			l.output.Close()
@@ -83,6 +86,13 @@ func (l *Logfile) rotate() {
 // Write implements io.Writer in order to be attached to log.New().
 func (l *Logfile) Write(b []byte) (int, error) {
 
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.closed {
+		return os.Stderr.Write(b) // late messages after Close
+	}
+
 	if l.output == nil {
 		l.rotate()
 		if l.output == nil {
@@ -111,3 +121,25 @@ func (l *Logfile) Write(b []byte) (int, error) {
 
 	return l.output.Write(b)
 }
+
+// Close flushes the log file to disk and closes it.
+// Later messages go to stderr.
+func (l *Logfile) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.closed = true
+
+	if l.output == nil {
+		return nil
+	}
+
+	syncErr := l.output.Sync()
+	closeErr := l.output.Close()
+	l.output = nil
+
+	if syncErr != nil {
+		return syncErr
+	}
+	return closeErr
+}
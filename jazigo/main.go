@@ -3,14 +3,18 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/icza/gowut/gwu"
@@ -27,6 +31,7 @@ const appVersion = "0.13.0"
 type app struct {
 	configPathPrefix string
 	repositoryPath   string // filesystem
+	modelsPath       string // declarative model files
 	logPathPrefix    string
 	configLock       lockfile.Lockfile
 	repositoryLock   lockfile.Lockfile
@@ -42,9 +47,10 @@ type app struct {
 	winAdmin  gwu.Window
 	winLogout gwu.Window
 
-	cssPath    string
-	repoPath   string // www
-	staticPath string // www
+	cssPath        string
+	repoPath       string // www
+	staticPath     string // www
+	transcriptPath string // www
 
 	logger *log.Logger
 
@@ -55,6 +61,15 @@ type app struct {
 	priority    chan string
 	requestChan chan dev.FetchRequest
 
+	ctx        context.Context    // cancelling aborts all fetches
+	abort      context.CancelFunc // cancels ctx
+	scanCancel context.CancelFunc // aborts the current scan, nil when no scan is running
+	scanLock   sync.Mutex
+
+	quit           chan struct{} // closed on shutdown: stops scanning
+	requestLock    sync.RWMutex  // guards requestChan against send after close
+	requestsClosed bool
+
 	filterTable *dev.FilterTable
 }
 
@@ -67,14 +82,19 @@ func (a *app) logf(fmt string, v ...interface{}) {
 }
 
 func newApp() *app {
+	ctx, abort := context.WithCancel(context.Background())
 	app := &app{
-		table:       dev.NewDeviceTable(),
-		options:     conf.NewOptions(),
-		logger:      log.New(os.Stdout, "", log.LstdFlags),
-		priority:    make(chan string),
-		requestChan: make(chan dev.FetchRequest),
-		repoPath:    "repo",   // www
-		staticPath:  "static", // www
+		table:          dev.NewDeviceTable(),
+		options:        conf.NewOptions(),
+		logger:         log.New(os.Stdout, "", log.LstdFlags),
+		priority:       make(chan string),
+		requestChan:    make(chan dev.FetchRequest),
+		ctx:            ctx,
+		abort:          abort,
+		quit:           make(chan struct{}),
+		repoPath:       "repo",       // www
+		staticPath:     "static",     // www
+		transcriptPath: "transcript", // www
 	}
 
 	return app
@@ -119,6 +139,7 @@ func main() {
 	var logMaxFiles int
 	var logMaxSize int64
 	var logCheckInterval time.Duration
+	var shutdownTimeout time.Duration
 	var webListen string
 	var s3region string
 	var version bool
@@ -128,10 +149,12 @@ func main() {
 	defaultRepo := filepath.Join(defaultHome, "repo")
 	defaultLogPrefix := filepath.Join(defaultHome, "log", "jazigo.log.")
 	defaultStaticDir := filepath.Join(defaultHome, "www")
+	defaultModelsDir := filepath.Join(defaultHome, "etc", "models")
 
 	flag.StringVar(&jaz.configPathPrefix, "configPathPrefix", defaultConfigPrefix, "configuration path prefix")
 	flag.StringVar(&jaz.repositoryPath, "repositoryPath", defaultRepo, "repository path")
 	flag.StringVar(&jaz.logPathPrefix, "logPathPrefix", defaultLogPrefix, "log path prefix")
+	flag.StringVar(&jaz.modelsPath, "modelsPath", defaultModelsDir, "directory for declarative model files")
 	flag.StringVar(&staticDir, "wwwStaticPath", defaultStaticDir, "directory for static www content")
 	flag.StringVar(&webListen, "webListen", ":8080", "address:port for web UI")
 	flag.StringVar(&s3region, "s3region", defaultRegionName(), "AWS S3 region")
@@ -145,6 +168,7 @@ func main() {
 	flag.IntVar(&logMaxFiles, "logMaxFiles", 20, "number of log files to keep")
 	flag.Int64Var(&logMaxSize, "logMaxSize", 10000000, "size limit for log file")
 	flag.DurationVar(&logCheckInterval, "logCheckInterval", time.Hour, "interval for checking log file size")
+	flag.DurationVar(&shutdownTimeout, "shutdownTimeout", 30*time.Second, "time allowed for backups in progress to finish on shutdown, before aborting them")
 	flag.Parse()
 
 	if version {
@@ -168,9 +192,12 @@ func main() {
 		jaz.logf("main: could not get exclusive lock: %v", lockErr)
 		panic("main: refusing to run without exclusive lock")
 	}
-	defer exclusiveUnlock(jaz)
 
 	fileLogger := NewLogfile(jaz.logPathPrefix, logMaxFiles, logMaxSize, logCheckInterval)
+	defer func() {
+		exclusiveUnlock(jaz)
+		fileLogger.Close() // flush log after last message
+	}()
 
 	// jaz.logger currently is stdout
 	if disableStdoutLog {
@@ -194,6 +221,8 @@ func main() {
 	store.Init(jaz.logger, s3region)
 
 	// load config
+	recoverStore(jaz)
+
 	loadConfig(jaz, maxMainConfigLoadSize)
 
 	jaz.logf("runOnce: %v", runOnce)
@@ -208,6 +237,7 @@ func main() {
 		return
 	}
 
+	dev.RecoverRepository(jaz.table, jaz.logger, jaz.repositoryPath)
 	dev.UpdateLastSuccess(jaz.table, jaz.logger, jaz.repositoryPath)
 
 	serverName := fmt.Sprintf("%s application", appName)
@@ -230,40 +260,130 @@ func main() {
 	jaz.logf("static dir: path=[%s] mapped to dir=[%s]", repoPathFull, jaz.repositoryPath)
 	server.AddStaticDir(repoPath, jaz.repositoryPath)
 
+	transcriptDir := dev.TranscriptRoot(jaz.logPathPrefix)
+	transcriptPathFull := fmt.Sprintf("/%s/%s", appName, jaz.transcriptPath)
+	jaz.logf("static dir: path=[%s] mapped to dir=[%s]", transcriptPathFull, transcriptDir)
+	server.AddStaticDir(jaz.transcriptPath, transcriptDir)
+
 	buildPublicWins(jaz, server)
 
-	go dev.Spawner(jaz.table, jaz.logger, jaz.requestChan, jaz.repositoryPath, jaz.logPathPrefix, jaz.options, jaz.filterTable)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	spawnerDone := make(chan struct{})
+	go func() {
+		dev.Spawner(jaz.ctx, jaz.table, jaz.logger, jaz.requestChan, jaz.repositoryPath, jaz.logPathPrefix, jaz.options, jaz.filterTable)
+		close(spawnerDone)
+	}()
+
+	scanDone := make(chan struct{})
 
 	if runOnce {
-		dev.Scan(jaz.table, jaz.table.ListDevices(), jaz.logger, jaz.options.Get(), jaz.requestChan)
-		close(jaz.requestChan) // shutdown Spawner
-		jaz.logf("runOnce: exiting after single scan")
+		go func() {
+			dev.Scan(jaz.ctx, jaz.quit, jaz.table, jaz.table.ListDevices(), jaz.logger, jaz.options.Get(), jaz.requestChan)
+			close(scanDone)
+		}()
+		select {
+		case <-scanDone:
+			jaz.closeRequests() // shutdown Spawner
+			<-spawnerDone
+			jaz.logf("runOnce: exiting after single scan")
+		case sig := <-signals:
+			jaz.logf("runOnce: received signal: %v", sig)
+			shutdown(jaz, scanDone, spawnerDone, shutdownTimeout)
+		}
 		return
 	}
 
-	go scanLoop(jaz)
+	go func() {
+		scanLoop(jaz)
+		close(scanDone)
+	}()
 
 	// Start GUI server
 	server.SetLogger(jaz.logger)
-	if err := server.Start(); err != nil {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+
+	select {
+	case err := <-serverErr:
 		jaz.logf("jazigo main: Cound not start GUI server: %s", err)
-		return
+	case sig := <-signals:
+		jaz.logf("jazigo main: received signal: %v", sig)
 	}
+
+	shutdown(jaz, scanDone, spawnerDone, shutdownTimeout)
 }
 
 func scanLoop(jaz *app) {
 	for {
+		select {
+		case <-jaz.quit:
+			jaz.logf("scanLoop: stopping")
+			return
+		default:
+		}
 		jaz.logf("scanLoop: starting")
 		opt := jaz.options.Get()
 		begin := time.Now()
-		dev.Scan(jaz.table, jaz.table.ListDevices(), jaz.logger, opt, jaz.requestChan)
+		ctx := jaz.scanBegin()
+		dev.Scan(ctx, jaz.quit, jaz.table, jaz.table.ListDevices(), jaz.logger, opt, jaz.requestChan)
+		jaz.scanEnd()
 		elap := time.Since(begin)
 		sleep := opt.ScanInterval - elap
 		if sleep < 1 {
 			sleep = 0
 		}
 		jaz.logf("scanLoop: sleeping for %s (target: scanInterval=%s)", sleep, opt.ScanInterval)
-		time.Sleep(sleep)
+		select {
+		case <-time.After(sleep):
+		case <-jaz.quit:
+			jaz.logf("scanLoop: stopping")
+			return
+		}
+	}
+}
+
+// scanBegin creates the context for a new scan, to be aborted with scanAbort.
+func (a *app) scanBegin() context.Context {
+	a.scanLock.Lock()
+	defer a.scanLock.Unlock()
+	ctx, cancel := context.WithCancel(a.ctx)
+	a.scanCancel = cancel
+	return ctx
+}
+
+func (a *app) scanEnd() {
+	a.scanLock.Lock()
+	defer a.scanLock.Unlock()
+	if a.scanCancel != nil {
+		a.scanCancel()
+		a.scanCancel = nil
+	}
+}
+
+// scanAbort cancels the current scan.
+// It reports false if no scan was running.
+func (a *app) scanAbort() bool {
+	a.scanLock.Lock()
+	defer a.scanLock.Unlock()
+	if a.scanCancel == nil {
+		return false
+	}
+	a.scanCancel()
+	return true
+}
+
+// recoverStore cleans up the config and log files after a crash.
+// It removes stale tmp files and rebuilds missing or inconsistent shortcut files.
+func recoverStore(jaz *app) {
+	for _, prefix := range []string{jaz.configPathPrefix, jaz.logPathPrefix} {
+		store.CleanStaleTmp(prefix, store.StaleTmpAge, jaz.logger)
+		if _, repairErr := store.RepairShortcut(prefix, jaz.logger); repairErr != nil {
+			jaz.logf("recoverStore: '%s': %v", prefix, repairErr)
+		}
 	}
 }
 
@@ -287,6 +407,10 @@ func loadConfig(jaz *app, maxSize int64) {
 
 	jaz.options.Set(&cfg.Options)
 
+	if modelsErr := dev.LoadModels(jaz.logger, jaz.table, jaz.modelsPath, cfg.Models); modelsErr != nil {
+		jaz.logger.Printf("loadConfig: %v", modelsErr)
+	}
+
 	for _, c := range cfg.Devices {
 		d, newErr := dev.NewDeviceFromConf(jaz.table, jaz.logger, &c)
 		if newErr != nil {
@@ -301,6 +425,24 @@ func loadConfig(jaz *app, maxSize int64) {
 	}
 }
 
+// reloadModels reloads declarative models from the models dir and from the last config file.
+func reloadModels(jaz *app, maxSize int64) error {
+	var defs []conf.ModelConfig
+
+	lastConfig, configErr := store.FindLastConfig(jaz.configPathPrefix, jaz.logger)
+	if configErr == nil {
+		cfg, loadErr := conf.Load(lastConfig, maxSize)
+		if loadErr != nil {
+			return fmt.Errorf("reloadModels: could not load config: '%s': %v", lastConfig, loadErr)
+		}
+		defs = cfg.Models
+	} else {
+		defs = jaz.table.ModelDefs()
+	}
+
+	return dev.LoadModels(jaz.logger, jaz.table, jaz.modelsPath, defs)
+}
+
 func manageDeviceList(jaz *app, imp, del, purge, list bool) error {
 	if del && purge {
 		return fmt.Errorf("deviceDelete and devicePurge are mutually exclusive")
@@ -436,7 +578,12 @@ func manageDeviceList(jaz *app, imp, del, purge, list bool) error {
 				value++
 			}
 
-			dev.CreateDevice(jaz.table, jaz.logger, f[0], id, f[2], f[3], f[4], f[5], enable, debug, nil)
+			host, hostErr := dev.NormalizeHostPort(f[2])
+			if hostErr != nil {
+				return fmt.Errorf("bad address in device line: [%s]: %v", text, hostErr)
+			}
+
+			dev.CreateDevice(jaz.table, jaz.logger, f[0], id, host, f[3], f[4], f[5], enable, debug, nil)
 		}
 
 		saveConfig(jaz, conf.Change{})
@@ -542,6 +689,8 @@ func saveConfig(jaz *app, change conf.Change) {
 	cfg.Options.LastChange = change  // record change
 	jaz.options.Set(&cfg.Options)    // update
 
+	cfg.Models = jaz.table.ModelDefs()
+
 	// copy devices from device table
 	cfg.Devices = make([]conf.DevConfig, len(devices))
 	for i, d := range devices {
@@ -564,7 +713,7 @@ func saveConfig(jaz *app, change conf.Change) {
 	}
 
 	// save
-	_, saveErr := store.SaveNewConfig(jaz.configPathPrefix, cfg.Options.MaxConfigFiles, jaz.logger, confWriteFunc, true, "detect")
+	_, saveErr := store.SaveNewConfig(jaz.configPathPrefix, cfg.Options.MaxConfigFiles, jaz.logger, confWriteFunc, true, "detect", nil)
 	if saveErr != nil {
 		jaz.logger.Printf("main: could not save config: %v", saveErr)
 	}
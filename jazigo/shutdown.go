@@ -0,0 +1,78 @@
+package main
+
+import (
+	"time"
+
+	"github.com/udhos/jazigo/dev"
+)
+
+// abortGrace is the time allowed for aborted fetches to unwind after the shutdown timeout.
+const abortGrace = 10 * time.Second
+
+// request sends a fetch request to the Spawner.
+// It reports false if the request channel was already closed by shutdown.
+func (a *app) request(req dev.FetchRequest) bool {
+	a.requestLock.RLock()
+	defer a.requestLock.RUnlock()
+	if a.requestsClosed {
+		return false
+	}
+	a.requestChan <- req
+	return true
+}
+
+// closeRequests closes the request channel, making the Spawner exit after the fetches in progress.
+func (a *app) closeRequests() {
+	a.requestLock.Lock()
+	defer a.requestLock.Unlock()
+	if a.requestsClosed {
+		return
+	}
+	a.requestsClosed = true
+	close(a.requestChan)
+}
+
+// shutdown stops scanning and waits for the fetches in progress.
+// Fetches still running after timeout are aborted, so they remove their tmp files.
+func shutdown(jaz *app, scanDone, spawnerDone <-chan struct{}, timeout time.Duration) {
+	jaz.logf("shutdown: waiting up to %s for backups in progress", timeout)
+
+	close(jaz.quit) // stop scanLoop and launching devices
+
+	if drain(jaz, scanDone, spawnerDone, timeout) {
+		jaz.logf("shutdown: backups in progress finished")
+		return
+	}
+
+	jaz.logf("shutdown: timeout: aborting backups in progress")
+	jaz.abort()
+
+	if drain(jaz, scanDone, spawnerDone, abortGrace) {
+		jaz.logf("shutdown: backups in progress aborted")
+		return
+	}
+
+	jaz.logf("shutdown: backups still in progress after %s, exiting anyway", abortGrace)
+}
+
+// drain waits for scanning to stop, then closes the request channel and waits for the Spawner to exit.
+// It reports false on timeout.
+func drain(jaz *app, scanDone, spawnerDone <-chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-scanDone:
+	case <-timer.C:
+		return false
+	}
+
+	jaz.closeRequests() // scan stopped: no more requests from scanLoop
+
+	select {
+	case <-spawnerDone:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/udhos/jazigo/dev"
+)
+
+func startTestSpawner(jaz *app) chan struct{} {
+	spawnerDone := make(chan struct{})
+	go func() {
+		dev.Spawner(jaz.ctx, jaz.table, jaz.logger, jaz.requestChan, "", "", jaz.options, nil)
+		close(spawnerDone)
+	}()
+	return spawnerDone
+}
+
+func TestShutdownDrain(t *testing.T) {
+	jaz := newApp()
+	spawnerDone := startTestSpawner(jaz)
+
+	// scan stops as soon as asked to
+	scanDone := make(chan struct{})
+	go func() {
+		<-jaz.quit
+		close(scanDone)
+	}()
+
+	shutdown(jaz, scanDone, spawnerDone, 5*time.Second)
+
+	if jaz.ctx.Err() != nil {
+		t.Errorf("drained shutdown aborted fetches")
+	}
+	if jaz.request(dev.FetchRequest{ID: "lab1"}) {
+		t.Errorf("request accepted after shutdown")
+	}
+}
+
+func TestShutdownAbort(t *testing.T) {
+	jaz := newApp()
+	spawnerDone := startTestSpawner(jaz)
+
+	// scan stops only when aborted
+	scanDone := make(chan struct{})
+	go func() {
+		<-jaz.ctx.Done()
+		close(scanDone)
+	}()
+
+	begin := time.Now()
+	shutdown(jaz, scanDone, spawnerDone, 100*time.Millisecond)
+
+	if jaz.ctx.Err() == nil {
+		t.Errorf("shutdown timeout did not abort fetches")
+	}
+	if elap := time.Since(begin); elap > abortGrace {
+		t.Errorf("shutdown took too long: %s", elap)
+	}
+	select {
+	case <-spawnerDone:
+	default:
+		t.Errorf("Spawner still running after shutdown")
+	}
+}
@@ -156,8 +156,10 @@ func buildDeviceWindow(jaz *app, e gwu.Event, devID string) string {
 
 	filesPanel := gwu.NewPanel()
 	filesMsg := gwu.NewLabel("No error")
+	quarantinePanel := gwu.NewHorizontalPanel()
 	filesTab := gwu.NewTable()
 	filesPanel.Add(filesMsg)
+	filesPanel.Add(quarantinePanel)
 	filesPanel.Add(filesTab)
 
 	filesTab.Style().AddClass("device_files_table")
@@ -174,15 +176,29 @@ func buildDeviceWindow(jaz *app, e gwu.Event, devID string) string {
 	propPanel.Add(propMsg)
 	propPanel.Add(propText)
 
+	hostKeyPanel := gwu.NewPanel()
+	hostKeyButtonAccept := gwu.NewButton("Re-accept")
+	hostKeyButtonAccept.SetAttr("title", "Store offered key or, if none was offered, forget stored key so next backup learns it")
+	hostKeyMsg := gwu.NewLabel("No error")
+	hostKeyStored := gwu.NewLabel("")
+	hostKeyOffered := gwu.NewLabel("")
+	hostKeyPanel.Add(hostKeyButtonAccept)
+	hostKeyPanel.Add(hostKeyMsg)
+	hostKeyPanel.Add(hostKeyStored)
+	hostKeyPanel.Add(hostKeyOffered)
+
 	showPanel := gwu.NewPanel()
 	logPanel := gwu.NewPanel()
 	diffPanel := gwu.NewPanel()
+	transcriptPanel := gwu.NewPanel()
 
-	panel.Add(gwu.NewLabel("Files"), filesPanel)      // tab 0
-	panel.Add(gwu.NewLabel("View Config"), showPanel) // tab 1
-	panel.Add(gwu.NewLabel("Properties"), propPanel)  // tab 2
-	panel.Add(gwu.NewLabel("Error Log"), logPanel)    // tab 3
-	panel.Add(gwu.NewLabel("Diff"), diffPanel)        // tab 4
+	panel.Add(gwu.NewLabel("Files"), filesPanel)            // tab 0
+	panel.Add(gwu.NewLabel("View Config"), showPanel)       // tab 1
+	panel.Add(gwu.NewLabel("Properties"), propPanel)        // tab 2
+	panel.Add(gwu.NewLabel("Error Log"), logPanel)          // tab 3
+	panel.Add(gwu.NewLabel("Diff"), diffPanel)              // tab 4
+	panel.Add(gwu.NewLabel("Host Key"), hostKeyPanel)       // tab 5
+	panel.Add(gwu.NewLabel("Transcripts"), transcriptPanel) // tab 6
 
 	const tabShow = 1 // index
 	const tabDiff = 4 // index
@@ -340,12 +356,100 @@ func buildDeviceWindow(jaz *app, e gwu.Event, devID string) string {
 
 	win.Add(panel)
 
-	fileList := func(e gwu.Event) {
+	var fileList func(e gwu.Event)
+
+	// quarantineList warns about a backup quarantined by the shrink guard
+	quarantineList := func(e gwu.Event) {
+		quarantinePanel.Clear()
+
+		prefix := dev.DeviceFullPrefix(jaz.repositoryPath, devID)
+		path := store.QuarantinePath(prefix)
+		if !store.FileExists(path) {
+			return
+		}
+
+		timeStr := "unknown"
+		modTime, size, infoErr := store.FileInfo(path)
+		if infoErr == nil {
+			timeStr = timestampString(modTime)
+		}
+
+		warn := gwu.NewLabel(fmt.Sprintf("WARNING: backup from %s (%d bytes) is much smaller than last one and was quarantined:", timeStr, size))
+		warn.Style().AddClass("quarantine_warning")
+
+		buttonView := gwu.NewButton("Open")
+		buttonView.AddEHandlerFunc(func(e gwu.Event) {
+			loadView(e, path)
+			panel.SetSelected(tabShow)
+		}, gwu.ETypeClick)
+
+		buttonDiff := gwu.NewButton("Diff")
+		buttonDiff.AddEHandlerFunc(func(e gwu.Event) {
+			last, lastErr := store.FindLastConfig(prefix, jaz.logger)
+			if lastErr != nil {
+				filesMsg.SetText(fmt.Sprintf("Find last config error: %v", lastErr))
+				e.MarkDirty(filesMsg)
+				return
+			}
+			loadDiff(e, last, path)
+			panel.SetSelected(tabDiff)
+		}, gwu.ETypeClick)
+
+		buttonAccept := gwu.NewButton("Accept")
+		buttonAccept.SetAttr("title", "Save quarantined backup as last config")
+		buttonAccept.SetEnabled(userIsLogged(e.Session()))
+		buttonAccept.AddEHandlerFunc(func(e gwu.Event) {
+			if !userIsLogged(e.Session()) {
+				return // refuse to change
+			}
+			newPath, acceptErr := store.AcceptQuarantine(prefix, jaz.options.Get().MaxConfigFiles, jaz.logger)
+			if acceptErr != nil {
+				jaz.logger.Printf("quarantine accept: device=%s: %v", devID, acceptErr)
+			} else {
+				jaz.logger.Printf("quarantine accepted: device=%s path=%s by=%s from=%s", devID, newPath, sessionUsername(e.Session()), eventRemoteAddress(e))
+				dev.SetDeviceQuarantine(jaz.table, devID, false, jaz.logger)
+			}
+			fileList(e)
+			if acceptErr != nil {
+				filesMsg.SetText(fmt.Sprintf("Accept error: %v", acceptErr))
+			}
+		}, gwu.ETypeClick)
+
+		buttonDiscard := gwu.NewButton("Discard")
+		buttonDiscard.SetEnabled(userIsLogged(e.Session()))
+		buttonDiscard.AddEHandlerFunc(func(e gwu.Event) {
+			if !userIsLogged(e.Session()) {
+				return // refuse to change
+			}
+			discardErr := store.DiscardQuarantine(prefix)
+			if discardErr != nil {
+				jaz.logger.Printf("quarantine discard: device=%s: %v", devID, discardErr)
+			} else {
+				jaz.logger.Printf("quarantine discarded: device=%s by=%s from=%s", devID, sessionUsername(e.Session()), eventRemoteAddress(e))
+				dev.SetDeviceQuarantine(jaz.table, devID, false, jaz.logger)
+			}
+			fileList(e)
+			if discardErr != nil {
+				filesMsg.SetText(fmt.Sprintf("Discard error: %v", discardErr))
+			}
+		}, gwu.ETypeClick)
+
+		quarantinePanel.Add(warn)
+		quarantinePanel.Add(buttonView)
+		quarantinePanel.Add(buttonDiff)
+		quarantinePanel.Add(buttonAccept)
+		quarantinePanel.Add(buttonDiscard)
+	}
+
+	fileList = func(e gwu.Event) {
+		defer e.MarkDirty(filesPanel)
+
+		quarantineList(e)
+
 		prefix := dev.DeviceFullPrefix(jaz.repositoryPath, devID)
 		dirname, matches, listErr := store.ListConfigSorted(prefix, true, jaz.logger)
 		if listErr != nil {
 			filesMsg.SetText(fmt.Sprintf("List files error: %v", listErr))
-			e.MarkDirty(filesPanel)
 			return
 		}
 
@@ -457,11 +561,115 @@ func buildDeviceWindow(jaz *app, e gwu.Event, devID string) string {
 		e.MarkDirty(propPanel)
 	}
 
+	loadHostKey := func(e gwu.Event) {
+		defer e.MarkDirty(hostKeyPanel)
+
+		hostKeyMsg.SetText("No error") // drop message from previous load or accept
+
+		d, getErr := jaz.table.GetDevice(devID)
+		if getErr != nil {
+			hostKeyMsg.SetText(fmt.Sprintf("Get device error: %v", getErr))
+			return
+		}
+
+		stored, offered, keyErr := dev.DeviceHostKey(jaz.repositoryPath, d.HostPort)
+		if keyErr != nil {
+			hostKeyMsg.SetText(fmt.Sprintf("Host key error: %v", keyErr))
+		}
+		if stored == "" {
+			stored = "none"
+		}
+		if offered == "" {
+			offered = "none"
+		}
+		hostKeyStored.SetText("Stored key: " + stored)
+		hostKeyOffered.SetText("Offered key (changed or unknown): " + offered)
+	}
+
+	loadTranscripts := func(e gwu.Event) {
+		defer e.MarkDirty(transcriptPanel)
+
+		transcriptPanel.Clear()
+
+		names, listErr := dev.ListTranscripts(jaz.logPathPrefix, devID)
+		if listErr != nil {
+			transcriptPanel.Add(gwu.NewLabel(fmt.Sprintf("List transcripts error: %v", listErr)))
+			return
+		}
+
+		transcriptPanel.Add(gwu.NewLabel(fmt.Sprintf("%d transcripts - enable recording with device property attr.transcriptcount", len(names))))
+
+		transcriptTab := gwu.NewTable()
+		transcriptTab.Style().AddClass("device_files_table")
+		transcriptBox := gwu.NewTextBox("")
+		transcriptBox.SetRows(30)
+		transcriptBox.SetCols(100)
+
+		const COLS = 4
+
+		row := 0
+
+		// header
+		transcriptTab.Add(gwu.NewLabel("Download"), row, 0)
+		transcriptTab.Add(gwu.NewLabel("View"), row, 1)
+		transcriptTab.Add(gwu.NewLabel("Size"), row, 2)
+		transcriptTab.Add(gwu.NewLabel("Time"), row, 3)
+
+		row++
+
+		dir := dev.TranscriptDir(jaz.logPathPrefix, devID)
+
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+
+			timeStr := "unknown"
+			modTime, size, infoErr := store.FileInfo(path)
+			if infoErr == nil {
+				timeStr = timestampString(modTime)
+			} else {
+				timeStr += fmt.Sprintf("(could not get file info: %v)", infoErr)
+			}
+
+			link := gwu.NewLink(name, fmt.Sprintf("%s/%s/%s", jaz.transcriptPath, devID, name))
+
+			buttonView := gwu.NewButton("Open")
+			buttonView.AddEHandlerFunc(func(e gwu.Event) {
+				options := jaz.options.Get()
+				b, readErr := store.FileRead(path, options.MaxConfigLoadSize)
+				if readErr != nil {
+					transcriptBox.SetText(fmt.Sprintf("Could not read '%s': %v", path, readErr))
+				} else {
+					transcriptBox.SetText(string(b))
+				}
+				e.MarkDirty(transcriptBox)
+			}, gwu.ETypeClick)
+
+			transcriptTab.Add(link, row, 0)
+			transcriptTab.Add(buttonView, row, 1)
+			transcriptTab.Add(gwu.NewLabel(strconv.FormatInt(size, 10)), row, 2)
+			transcriptTab.Add(gwu.NewLabel(timeStr), row, 3)
+
+			row++
+		}
+
+		for r := 0; r < row; r++ {
+			for j := 0; j < COLS; j++ {
+				transcriptTab.CellFmt(r, j).Style().AddClass("device_files_cell")
+			}
+		}
+
+		transcriptPanel.Add(transcriptTab)
+		transcriptPanel.Add(transcriptBox)
+	}
+
 	refresh := func(e gwu.Event) {
 		propButtonSave.SetEnabled(userIsLogged(e.Session()))
-		fileList(e)  // build file list
-		resetProp(e) // build file properties
-		loadLog(e)   // load log
+		hostKeyButtonAccept.SetEnabled(userIsLogged(e.Session()))
+		fileList(e)        // build file list
+		resetProp(e)       // build file properties
+		loadLog(e)         // load log
+		loadHostKey(e)     // load host key
+		loadTranscripts(e) // list transcripts
 		e.MarkDirty(win)
 	}
 
@@ -485,6 +693,13 @@ func buildDeviceWindow(jaz *app, e gwu.Event, devID string) string {
 			return
 		}
 
+		host, hostErr := dev.NormalizeHostPort(c.HostPort)
+		if hostErr != nil {
+			propMsg.SetText(fmt.Sprintf("Invalid host: %v", hostErr))
+			return
+		}
+		c.HostPort = host
+
 		d, getErr := jaz.table.GetDevice(devID)
 		if getErr != nil {
 			propMsg.SetText(fmt.Sprintf("Get device error: %v", getErr))
@@ -511,6 +726,33 @@ func buildDeviceWindow(jaz *app, e gwu.Event, devID string) string {
 
 	}, gwu.ETypeClick)
 
+	hostKeyButtonAccept.AddEHandlerFunc(func(e gwu.Event) {
+
+		defer e.MarkDirty(hostKeyPanel)
+
+		if !userIsLogged(e.Session()) {
+			return // refuse to change
+		}
+
+		d, getErr := jaz.table.GetDevice(devID)
+		if getErr != nil {
+			hostKeyMsg.SetText(fmt.Sprintf("Get device error: %v", getErr))
+			return
+		}
+
+		if acceptErr := dev.AcceptHostKey(jaz.repositoryPath, d.HostPort); acceptErr != nil {
+			hostKeyMsg.SetText(fmt.Sprintf("Accept host key error: %v", acceptErr))
+			return
+		}
+
+		jaz.logger.Printf("host key re-accepted: device=%s by=%s from=%s", devID, sessionUsername(e.Session()), eventRemoteAddress(e))
+
+		loadHostKey(e)
+
+		hostKeyMsg.SetText("Host key accepted.")
+
+	}, gwu.ETypeClick)
+
 	refreshButton.AddEHandlerFunc(refresh, gwu.ETypeClick)
 
 	win.AddEHandlerFunc(refresh, gwu.ETypeWinLoad)
@@ -618,6 +860,21 @@ func buildDeviceTable(jaz *app, s gwu.Session, t gwu.Table, tabSumm gwu.Panel) {
 		} else {
 			imageLastStatus = gwu.NewImage("Failure", fmt.Sprintf("%s/fail-small.png", jaz.staticPath))
 		}
+		running := jaz.table.FetchRunning(d.ID)
+		lastStatus := gwu.NewHorizontalPanel()
+		lastStatus.Add(imageLastStatus)
+		if running {
+			busy := gwu.NewLabel("running")
+			busy.SetAttr("title", "Backup in progress")
+			busy.Style().AddClass("fetch_running")
+			lastStatus.Add(busy)
+		}
+		if d.Quarantined() {
+			warn := gwu.NewLabel("quarantined")
+			warn.SetAttr("title", "Suspect backup quarantined - open device to accept it")
+			warn.Style().AddClass("quarantine_warning")
+			lastStatus.Add(warn)
+		}
 		labElapsed := gwu.NewLabel(durationSecString(d.LastElapsed()))
 		labLastTry := gwu.NewLabel(timestampString(d.LastTry()))
 		labLastSuccess := gwu.NewLabel(timestampString(d.LastSuccess()))
@@ -628,22 +885,42 @@ func buildDeviceTable(jaz *app, s gwu.Session, t gwu.Table, tabSumm gwu.Panel) {
 		labHoldtime := gwu.NewLabel(durationSecString(h))
 
 		buttonRun := gwu.NewButton("Run")
+		buttonRun.SetEnabled(!running)
 		id := d.ID
 		buttonRun.AddEHandlerFunc(func(e gwu.Event) {
 			// run in a goroutine to not block the UI on channel write
 			go runPriority(jaz, id)
 		}, gwu.ETypeClick)
 
+		buttonAbort := gwu.NewButton("Abort")
+		buttonAbort.SetAttr("title", "Abort backup in progress")
+		buttonAbort.SetEnabled(running && userIsLogged(s))
+		buttonAbort.AddEHandlerFunc(func(e gwu.Event) {
+			if !userIsLogged(e.Session()) {
+				return // refuse to abort
+			}
+			if jaz.table.AbortFetch(id) {
+				jaz.logger.Printf("abort: device %s: by=%s from=%s", id, sessionUsername(e.Session()), eventRemoteAddress(e))
+			} else {
+				jaz.logger.Printf("abort: device %s: no backup in progress", id)
+			}
+			refreshDeviceTable(jaz, t, tabSumm, e)
+		}, gwu.ETypeClick)
+
+		run := gwu.NewHorizontalPanel()
+		run.Add(buttonRun)
+		run.Add(buttonAbort)
+
 		t.Add(labMod, row, 0)
 		t.Add(buttonID, row, 1)
 		t.Add(labHost, row, 2)
 		t.Add(labTransport, row, 3)
-		t.Add(imageLastStatus, row, 4)
+		t.Add(lastStatus, row, 4)
 		t.Add(labElapsed, row, 5)
 		t.Add(labLastTry, row, 6)
 		t.Add(labLastSuccess, row, 7)
 		t.Add(labHoldtime, row, 8)
-		t.Add(buttonRun, row, 9)
+		t.Add(run, row, 9)
 
 		row++
 	}
@@ -667,7 +944,9 @@ func runPriority(jaz *app, id string) {
 		return
 	}
 
-	jaz.requestChan <- dev.FetchRequest{ID: id}
+	if !jaz.request(dev.FetchRequest{ID: id}) {
+		jaz.logger.Printf("runPriority: device %s: shutting down, request dropped", id)
+	}
 }
 
 func refreshDeviceTable(jaz *app, t gwu.Table, tabSumm gwu.Panel, e gwu.Event) {
@@ -695,9 +974,15 @@ func buildHomeWin(jaz *app, s gwu.Session) {
 
 	createButton := gwu.NewButton("Create")
 
+	abortScanButton := gwu.NewButton("Abort Scan")
+	abortScanButton.SetAttr("title", "Abort backups in progress for current scan and skip remaining devices")
+	abortScanButton.SetEnabled(userIsLogged(s))
+
 	refresh := func(e gwu.Event) {
 		createButton.SetEnabled(userIsLogged(e.Session()))
 		e.MarkDirty(createButton)
+		abortScanButton.SetEnabled(userIsLogged(e.Session()))
+		e.MarkDirty(abortScanButton)
 		refreshDeviceTable(jaz, t, tableSumm, e)
 	}
 
@@ -705,7 +990,23 @@ func buildHomeWin(jaz *app, s gwu.Session) {
 
 	refreshButton := gwu.NewButton("Refresh")
 	refreshButton.AddEHandlerFunc(refresh, gwu.ETypeClick)
-	win.Add(refreshButton)
+
+	abortScanButton.AddEHandlerFunc(func(e gwu.Event) {
+		if !userIsLogged(e.Session()) {
+			return // refuse to abort
+		}
+		if jaz.scanAbort() {
+			jaz.logger.Printf("abort scan: by=%s from=%s", sessionUsername(e.Session()), eventRemoteAddress(e))
+		} else {
+			jaz.logger.Printf("abort scan: no scan in progress")
+		}
+		refresh(e)
+	}, gwu.ETypeClick)
+
+	buttons := gwu.NewHorizontalPanel()
+	buttons.Add(refreshButton)
+	buttons.Add(abortScanButton)
+	win.Add(buttons)
 
 	win.AddEHandlerFunc(refresh, gwu.ETypeWinLoad)
 
@@ -830,7 +1131,12 @@ func buildCreateDevPanel(jaz *app, s gwu.Session, refresh func(gwu.Event), creat
 			return
 		}
 
-		host := strings.TrimSpace(textHost.Text())
+		host, hostErr := dev.NormalizeHostPort(textHost.Text())
+		if hostErr != nil {
+			msg.SetText(fmt.Sprintf("Invalid host: %v", hostErr))
+			e.MarkDirty(createDevPanel)
+			return
+		}
 		textHost.SetText(host)
 		e.MarkDirty(textHost)
 
@@ -1163,6 +1469,71 @@ func buildAdminWin(jaz *app, s gwu.Session) {
 
 	win.Add(settingsPanel)
 
+	modelsPanel := gwu.NewPanel()
+	modelsButtonReload := gwu.NewButton("Reload")
+	modelsButtonReload.SetAttr("title", "Reload declarative models from models dir "+jaz.modelsPath+" and from config Models section. Devices take new model attributes they do not override")
+	modelsMsg := gwu.NewLabel("No error")
+	modelsTab := gwu.NewTable()
+	modelsTab.Style().AddClass("device_files_table")
+	modelsPanel.Add(gwu.NewLabel("Models"))
+	modelsPanel.Add(modelsButtonReload)
+	modelsPanel.Add(modelsMsg)
+	modelsPanel.Add(modelsTab)
+
+	loadModels := func() {
+		modelsTab.Clear()
+
+		models := jaz.table.ListModels()
+		sort.Strings(models)
+
+		modelsTab.Add(gwu.NewLabel("Model"), 0, 0)
+		modelsTab.Add(gwu.NewLabel("Source"), 0, 1)
+
+		for i, m := range models {
+			modelsTab.Add(gwu.NewLabel(m), i+1, 0)
+			modelsTab.Add(gwu.NewLabel(jaz.table.ModelSource(m)), i+1, 1)
+		}
+
+		for r := 0; r <= len(models); r++ {
+			for j := 0; j < 2; j++ {
+				modelsTab.CellFmt(r, j).Style().AddClass("device_files_cell")
+			}
+		}
+	}
+
+	loadModels() // first run
+
+	modelsButtonReload.SetEnabled(userIsLogged(s))
+
+	modelsButtonReload.AddEHandlerFunc(func(e gwu.Event) {
+
+		if !userIsLogged(e.Session()) {
+			return // refuse to reload
+		}
+
+		defer e.MarkDirty(modelsPanel)
+
+		options := jaz.options.Get()
+		if reloadErr := reloadModels(jaz, options.MaxConfigLoadSize); reloadErr != nil {
+			modelsMsg.SetText(fmt.Sprintf("Reload error: %v", reloadErr))
+		} else {
+			modelsMsg.SetText("Reloaded. Devices using reloaded models got the new attributes they do not override.")
+		}
+
+		jaz.logger.Printf("models reloaded: by=%s from=%s", sessionUsername(e.Session()), eventRemoteAddress(e))
+
+		loadModels()
+
+	}, gwu.ETypeClick)
+
+	win.Add(modelsPanel)
+
+	win.AddEHandlerFunc(func(e gwu.Event) {
+		modelsButtonReload.SetEnabled(userIsLogged(e.Session()))
+		loadModels()
+		e.MarkDirty(modelsPanel)
+	}, gwu.ETypeWinLoad)
+
 	win.AddEHandlerFunc(refresh, gwu.ETypeWinLoad)
 
 	s.AddWin(win)
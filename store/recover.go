@@ -0,0 +1,207 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StaleTmpAge is the age past which a tmp file is considered left behind by a crash.
+// It must exceed the longest backup, since a tmp file is written while the device is fetched.
+const StaleTmpAge = time.Hour
+
+// newTmpPath gets a unique tmp file path under a path prefix.
+// The name ends in ".tmp", not in a digit, so it is never taken as a config file.
+func newTmpPath(configPathPrefix string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return getConfigPath(configPathPrefix, "tmp-"+hex.EncodeToString(b)+".tmp"), nil
+}
+
+// isTmpName checks if a file name under basename is a tmp file, including the legacy fixed names.
+func isTmpName(basename, name string) bool {
+	if name == basename+"tmp" || name == basename+"last.tmp" {
+		return true
+	}
+	return strings.HasPrefix(name, basename+"tmp-") && strings.HasSuffix(name, ".tmp")
+}
+
+// ListTmp retrieves the tmp files under a path prefix.
+func ListTmp(configPathPrefix string) ([]string, error) {
+	dirname, names, dirErr := dirList(configPathPrefix)
+	if dirErr != nil {
+		return nil, dirErr
+	}
+	basename := filepath.Base(configPathPrefix)
+	var list []string
+	for _, n := range names {
+		if isTmpName(basename, n) {
+			list = append(list, filepath.Join(dirname, n))
+		}
+	}
+	return list, nil
+}
+
+// CleanStaleTmp removes tmp files older than maxAge under a path prefix.
+// It returns the number of files removed.
+func CleanStaleTmp(configPathPrefix string, maxAge time.Duration, logger hasPrintf) int {
+	list, listErr := ListTmp(configPathPrefix)
+	if listErr != nil {
+		if !errors.Is(listErr, os.ErrNotExist) {
+			logger.Printf("CleanStaleTmp: %v", listErr)
+		}
+		return 0 // no directory yet
+	}
+
+	now := time.Now()
+	removed := 0
+
+	for _, path := range list {
+		mod, _, infoErr := FileInfo(path)
+		if infoErr != nil {
+			logger.Printf("CleanStaleTmp: [%s]: %v", path, infoErr)
+			continue
+		}
+		if age := now.Sub(mod); age < maxAge {
+			continue
+		}
+		if removeErr := fileRemove(path); removeErr != nil {
+			logger.Printf("CleanStaleTmp: remove: [%s]: %v", path, removeErr)
+			continue
+		}
+		logger.Printf("CleanStaleTmp: removed stale tmp file: [%s] modified=%s", path, mod)
+		removed++
+	}
+
+	return removed
+}
+
+// findLastByScan finds the highest commit id under a path prefix by listing the directory, ignoring the shortcut file.
+func findLastByScan(configPathPrefix string, logger hasPrintf) (string, int, error) {
+
+	dirname, matches, err := ListConfig(configPathPrefix, logger)
+	if err != nil {
+		return "", -1, err
+	}
+
+	size := len(matches)
+
+	logger.Printf("FindLastConfig: found %d matching files: %v", size, matches)
+
+	if size < 1 {
+		return "", -1, fmt.Errorf("FindLastConfig: no config file found for prefix: %s", configPathPrefix)
+	}
+
+	maxID := -1
+	last := ""
+	for _, m := range matches {
+		id, idErr := ExtractCommitIDFromFilename(m)
+		if idErr != nil {
+			return "", -1, fmt.Errorf("FindLastConfig: bad commit id: %s: %v", m, idErr)
+		}
+		if id >= maxID {
+			maxID = id
+			last = m
+		}
+	}
+
+	return filepath.Join(dirname, last), maxID, nil
+}
+
+// RepairShortcut rebuilds the last id shortcut file when it is missing or does not point to the last config.
+// It reports whether the shortcut was rewritten.
+func RepairShortcut(configPathPrefix string, logger hasPrintf) (bool, error) {
+
+	lastIDPath := getLastIDPath(configPathPrefix)
+
+	_, maxID, scanErr := findLastByScan(configPathPrefix, logger)
+	if scanErr != nil {
+		if fileExists(lastIDPath) {
+			// shortcut pointing to nothing
+			if removeErr := fileRemove(lastIDPath); removeErr != nil {
+				return false, fmt.Errorf("RepairShortcut: remove: [%s]: %v", lastIDPath, removeErr)
+			}
+			logger.Printf("RepairShortcut: no config file: removed shortcut [%s]", lastIDPath)
+			return true, nil
+		}
+		return false, nil // nothing saved yet
+	}
+
+	want := strconv.Itoa(maxID)
+
+	if id, readErr := fileFirstLine(lastIDPath); readErr == nil && id == want {
+		return false, nil // consistent
+	}
+
+	if writeErr := writeShortcut(configPathPrefix, maxID, ""); writeErr != nil {
+		return false, fmt.Errorf("RepairShortcut: [%s]: %v", lastIDPath, writeErr)
+	}
+
+	logger.Printf("RepairShortcut: rebuilt shortcut [%s] => %s", lastIDPath, want)
+
+	return true, nil
+}
+
+// writeShortcut writes the last id into the shortcut file under a path prefix.
+// A local shortcut is written to a unique tmp file and renamed over, so a crash never leaves it partially written
+// and concurrent writers never share a tmp file.
+func writeShortcut(configPathPrefix string, id int, contentType string) error {
+	buf := []byte(strconv.Itoa(id))
+
+	lastIDPath := getLastIDPath(configPathPrefix)
+
+	if s3path(lastIDPath) {
+		return writeFileBuf(lastIDPath, buf, contentType)
+	}
+
+	tmpPath, tmpErr := newTmpPath(configPathPrefix)
+	if tmpErr != nil {
+		return tmpErr
+	}
+
+	f, createErr := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if createErr != nil {
+		return createErr
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, lastIDPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return syncDir(filepath.Dir(lastIDPath))
+}
+
+// syncDir flushes a local directory to disk, making renames within it durable.
+func syncDir(dirname string) error {
+	d, openErr := os.Open(dirname)
+	if openErr != nil {
+		return openErr
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}
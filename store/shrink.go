@@ -0,0 +1,173 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ShrinkGuard holds thresholds for a new config much smaller than the last one.
+// A new config shrinking past any threshold is likely cut short: it is quarantined instead of becoming the last config.
+// Zero disables a threshold.
+type ShrinkGuard struct {
+	Percent int // max size decrease, in percent of last config size
+	Lines   int // max line count decrease
+}
+
+// shrunk checks if the size or line count decrease goes past the thresholds.
+func (g *ShrinkGuard) shrunk(lastSize, newSize, lastLines, newLines int64) bool {
+	if g.Percent > 0 && lastSize > 0 && (lastSize-newSize)*100 > int64(g.Percent)*lastSize {
+		return true
+	}
+	if g.Lines > 0 && lastLines-newLines > int64(g.Lines) {
+		return true
+	}
+	return false
+}
+
+// ShrinkError reports a new config quarantined by the shrink guard.
+type ShrinkError struct {
+	Path      string // quarantine file
+	Last      string // last config
+	LastSize  int64
+	NewSize   int64
+	LastLines int64
+	NewLines  int64
+}
+
+func (e *ShrinkError) Error() string {
+	return fmt.Sprintf("new config shrank past guard: size %d->%d lines %d->%d: quarantined as [%s]",
+		e.LastSize, e.NewSize, e.LastLines, e.NewLines, e.Path)
+}
+
+// QuarantinePath gets the path for the quarantined config under a path prefix.
+// Its name does not end in a commit id, so it is never rotated nor taken as last config.
+func QuarantinePath(configPathPrefix string) string {
+	return getConfigPath(configPathPrefix, "quarantine")
+}
+
+// checkShrink compares the closed tmp file with the last config.
+// A tmp file shrinking past the guard is moved to the quarantine file, replacing any previous one.
+func (w *ConfigWriter) checkShrink(lastConfig string, guard *ShrinkGuard, logger hasPrintf) error {
+
+	lastSize, lastLines, statErr := fileStats(lastConfig)
+	if statErr != nil {
+		logger.Printf("SaveNewConfig: shrink guard: could not read previous=[%s]: %v", lastConfig, statErr)
+		return nil // unable to compare
+	}
+
+	if !guard.shrunk(lastSize, w.size, lastLines, w.lines) {
+		return nil
+	}
+
+	quarantine := QuarantinePath(w.configPathPrefix)
+
+	if fileExists(quarantine) {
+		if removeErr := fileRemove(quarantine); removeErr != nil {
+			return fmt.Errorf("SaveNewConfig: shrink guard: could not remove old quarantine [%s]: %v", quarantine, removeErr)
+		}
+	}
+
+	if renameErr := fileRename(w.tmpPath, quarantine); renameErr != nil {
+		return fmt.Errorf("SaveNewConfig: shrink guard: could not rename '%s' to '%s'; %v", w.tmpPath, quarantine, renameErr)
+	}
+
+	logger.Printf("SaveNewConfig: shrink guard: previous=[%s] size=%d lines=%d new size=%d lines=%d: quarantined as [%s]",
+		lastConfig, lastSize, lastLines, w.size, w.lines, quarantine)
+
+	return &ShrinkError{Path: quarantine, Last: lastConfig, LastSize: lastSize, NewSize: w.size, LastLines: lastLines, NewLines: w.lines}
+}
+
+// fileStats gets file size and line count.
+func fileStats(path string) (int64, int64, error) {
+
+	var r io.ReadCloser
+
+	if s3path(path) {
+		r1, readErr := s3fileReader(path)
+		if readErr != nil {
+			return 0, 0, readErr
+		}
+		r = r1
+	} else {
+		f, openErr := os.Open(path)
+		if openErr != nil {
+			return 0, 0, openErr
+		}
+		r = f
+	}
+
+	defer r.Close()
+
+	var size, lines int64
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		size += int64(n)
+		lines += int64(bytes.Count(buf[:n], []byte{'\n'}))
+		if err == io.EOF {
+			return size, lines, nil
+		}
+		if err != nil {
+			return size, lines, err
+		}
+	}
+}
+
+// AcceptQuarantine turns the quarantined config into the next config file.
+func AcceptQuarantine(configPathPrefix string, maxFiles int, logger hasPrintf) (string, error) {
+
+	quarantine := QuarantinePath(configPathPrefix)
+	if !fileExists(quarantine) {
+		return "", fmt.Errorf("AcceptQuarantine: no quarantined config: [%s]", quarantine)
+	}
+
+	id := -1
+	lastConfig, findErr := FindLastConfig(configPathPrefix, logger)
+	if findErr == nil {
+		quarantineMod, _, qErr := FileInfo(quarantine)
+		if qErr != nil {
+			return "", fmt.Errorf("AcceptQuarantine: %v", qErr)
+		}
+		lastMod, _, lastErr := FileInfo(lastConfig)
+		if lastErr != nil {
+			return "", fmt.Errorf("AcceptQuarantine: %v", lastErr)
+		}
+		if quarantineMod.Before(lastMod) {
+			return "", fmt.Errorf("AcceptQuarantine: quarantined config [%s] is older than last config [%s]", quarantine, lastConfig)
+		}
+		var idErr error
+		if id, idErr = ExtractCommitIDFromFilename(lastConfig); idErr != nil {
+			return "", fmt.Errorf("AcceptQuarantine: %v", idErr)
+		}
+	}
+
+	path, rotateErr := rotateIn(configPathPrefix, quarantine, id, maxFiles, logger, "")
+	if rotateErr != nil {
+		return "", fmt.Errorf("AcceptQuarantine: %v", rotateErr)
+	}
+
+	return path, nil
+}
+
+// dropQuarantine removes the quarantined config superseded by a newer config, if any.
+func dropQuarantine(configPathPrefix string, logger hasPrintf) {
+	quarantine := QuarantinePath(configPathPrefix)
+	if !fileExists(quarantine) {
+		return
+	}
+	if err := fileRemove(quarantine); err != nil {
+		logger.Printf("SaveNewConfig: could not remove superseded quarantine [%s]: %v", quarantine, err)
+		return
+	}
+	logger.Printf("SaveNewConfig: removed superseded quarantine [%s]", quarantine)
+}
+
+// DiscardQuarantine removes the quarantined config.
+func DiscardQuarantine(configPathPrefix string) error {
+	if err := fileRemove(QuarantinePath(configPathPrefix)); err != nil {
+		return fmt.Errorf("DiscardQuarantine: %v", err)
+	}
+	return nil
+}
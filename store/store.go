@@ -110,36 +110,19 @@ func FindLastConfig(configPathPrefix string, logger hasPrintf) (string, error) {
 
 	// search filesystem directory
 
-	dirname, matches, err := ListConfig(configPathPrefix, logger)
-	if err != nil {
-		return "", err
+	lastPath, maxID, scanErr := findLastByScan(configPathPrefix, logger)
+	if scanErr != nil {
+		return "", scanErr
 	}
 
-	size := len(matches)
-
-	logger.Printf("FindLastConfig: found %d matching files: %v", size, matches)
-
-	if size < 1 {
-		return "", fmt.Errorf("FindLastConfig: no config file found for prefix: %s", configPathPrefix)
-	}
+	logger.Printf("FindLastConfig: found: %s", lastPath)
 
-	maxID := -1
-	last := ""
-	for _, m := range matches {
-		id, idErr := ExtractCommitIDFromFilename(m)
-		if idErr != nil {
-			return "", fmt.Errorf("FindLastConfig: bad commit id: %s: %v", m, idErr)
-		}
-		if id >= maxID {
-			maxID = id
-			last = m
-		}
+	// shortcut missing or pointing to missing file
+	lastIDPath := getLastIDPath(configPathPrefix)
+	if err := writeShortcut(configPathPrefix, maxID, ""); err != nil {
+		logger.Printf("FindLastConfig: error rebuilding shortcut '%s': %v", lastIDPath, err)
 	}
 
-	lastPath := filepath.Join(dirname, last)
-
-	logger.Printf("FindLastConfig: found: %s", lastPath)
-
 	return lastPath, nil
 }
 
@@ -170,7 +153,7 @@ func dirList(path string) (string, []string, error) {
 
 	dir, err := os.Open(dirname)
 	if err != nil {
-		return dirname, nil, fmt.Errorf("ListConfig: error opening dir '%s': %v", dirname, err)
+		return dirname, nil, fmt.Errorf("ListConfig: error opening dir '%s': %w", dirname, err)
 	}
 
 	defer dir.Close()
@@ -236,6 +219,11 @@ func fileExists(path string) bool {
 	return false
 }
 
+// FileExists checks if file exists.
+func FileExists(path string) bool {
+	return fileExists(path)
+}
+
 func fileRemove(path string) error {
 
 	if s3path(path) {
@@ -287,6 +275,11 @@ func FileRead(path string, maxSize int64) ([]byte, error) {
 	return buf, nil
 }
 
+// FileWrite writes bytes to file, replacing previous contents.
+func FileWrite(path string, buf []byte) error {
+	return writeFileBuf(path, buf, "")
+}
+
 func writeFileBuf(path string, buf []byte, contentType string) error {
 
 	if s3path(path) {
@@ -297,55 +290,147 @@ func writeFileBuf(path string, buf []byte, contentType string) error {
 	return err
 }
 
-func writeFile(path string, writeFunc func(HasWrite) error, contentType string) error {
+// ConfigWriter streams a new config into the tmp file.
+// Commit turns the tmp file into the next config file, Discard drops it.
+// S3 objects can't be appended to, so data for S3 is buffered in memory until Commit.
+type ConfigWriter struct {
+	configPathPrefix string
+	tmpPath          string
+	contentType      string
+	file             *os.File      // local file
+	writer           *bufio.Writer // local file
+	buf              *bytes.Buffer // S3 object
+	size             int64
+	lines            int64
+	closed           bool
+}
 
-	if s3path(path) {
-		w := &bytes.Buffer{}
+// NewConfigWriter creates the tmp file for a new config under a path prefix.
+// Every writer gets a unique tmp file, so a tmp file left behind by a crash does not block new configs.
+func NewConfigWriter(configPathPrefix, contentType string) (*ConfigWriter, error) {
 
-		if err := writeFunc(w); err != nil {
-			return fmt.Errorf("SaveNewConfig: writeFunc error: [%s]: %v", path, err)
-		}
+	tmpPath, nameErr := newTmpPath(configPathPrefix)
+	if nameErr != nil {
+		return nil, fmt.Errorf("NewConfigWriter: tmp file name: %v", nameErr)
+	}
 
-		return s3fileput(path, w.Bytes(), contentType)
+	w := &ConfigWriter{configPathPrefix: configPathPrefix, tmpPath: tmpPath, contentType: contentType}
+
+	if s3path(tmpPath) {
+		w.buf = &bytes.Buffer{}
+		return w, nil
 	}
 
-	f, createErr := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0640)
+	f, createErr := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
 	if createErr != nil {
-		return fmt.Errorf("SaveNewConfig: error creating file: [%s]: %v", path, createErr)
+		return nil, fmt.Errorf("NewConfigWriter: error creating file: [%s]: %v", tmpPath, createErr)
 	}
 
-	w := bufio.NewWriter(f)
+	w.file = f
+	w.writer = bufio.NewWriter(f)
 
-	if err := writeFunc(w); err != nil {
-		return fmt.Errorf("SaveNewConfig: writeFunc error: [%s]: %v", path, err)
+	return w, nil
+}
+
+// Write appends data to the tmp file.
+func (w *ConfigWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("ConfigWriter.Write: closed: [%s]", w.tmpPath)
+	}
+	var n int
+	var err error
+	if w.buf != nil {
+		n, err = w.buf.Write(p)
+	} else {
+		n, err = w.writer.Write(p)
+	}
+	w.size += int64(n)
+	w.lines += int64(bytes.Count(p[:n], []byte{'\n'}))
+	return n, err
+}
+
+// Size reports how many bytes have been written so far.
+func (w *ConfigWriter) Size() int64 {
+	return w.size
+}
+
+// close flushes data into the tmp file.
+func (w *ConfigWriter) close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if w.buf != nil {
+		return s3fileput(w.tmpPath, w.buf.Bytes(), w.contentType)
+	}
+
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("error flushing file: [%s]: %v", w.tmpPath, err)
 	}
 
-	if err := w.Flush(); err != nil {
-		return fmt.Errorf("SaveNewConfig: error flushing file: [%s]: %v", path, err)
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("error syncing file: [%s]: %v", w.tmpPath, err)
 	}
 
-	if err := f.Close(); err != nil {
-		return fmt.Errorf("SaveNewConfig: error closing file: [%s]: %v", path, err)
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("error closing file: [%s]: %v", w.tmpPath, err)
 	}
 
 	return nil
 }
 
+// Discard drops the tmp file.
+func (w *ConfigWriter) Discard() error {
+	if !w.closed {
+		w.closed = true
+		if w.buf != nil {
+			w.buf = nil
+			return nil // nothing uploaded yet
+		}
+		w.file.Close()
+	}
+	if err := fileRemove(w.tmpPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("ConfigWriter.Discard: [%s]: %v", w.tmpPath, err)
+	}
+	return nil
+}
+
 // SaveNewConfig saves data to a new file. The function writeFunc must be provided to issue the actual data.
-func SaveNewConfig(configPathPrefix string, maxFiles int, logger hasPrintf, writeFunc func(HasWrite) error, changesOnly bool, contentType string) (string, error) {
+// A nil guard disables the shrink guard.
+func SaveNewConfig(configPathPrefix string, maxFiles int, logger hasPrintf, writeFunc func(HasWrite) error, changesOnly bool, contentType string, guard *ShrinkGuard) (string, error) {
 
-	// get tmp file
+	w, newErr := NewConfigWriter(configPathPrefix, contentType)
+	if newErr != nil {
+		return "", fmt.Errorf("SaveNewConfig: %v", newErr)
+	}
 
-	tmpPath := getConfigPath(configPathPrefix, "tmp")
-	if fileExists(tmpPath) {
-		return "", fmt.Errorf("SaveNewConfig: tmp file exists: [%s]", tmpPath)
+	if err := writeFunc(w); err != nil {
+		w.Discard()
+		return "", fmt.Errorf("SaveNewConfig: writeFunc error: [%s]: %v", w.tmpPath, err)
 	}
 
+	return w.Commit(maxFiles, logger, changesOnly, guard)
+}
+
+// Commit closes the tmp file and renames it to the next config file, then erases old files beyond maxFiles.
+// With changesOnly, a tmp file identical to the last config is dropped and the last config path is returned.
+// A new config shrinking past the guard is quarantined, see ShrinkGuard. A successful commit removes any quarantined config.
+func (w *ConfigWriter) Commit(maxFiles int, logger hasPrintf, changesOnly bool, guard *ShrinkGuard) (string, error) {
+
+	configPathPrefix := w.configPathPrefix
+	tmpPath := w.tmpPath
+	contentType := w.contentType
+
+	CleanStaleTmp(configPathPrefix, StaleTmpAge, logger)
+
 	// write to tmp file
 
-	creatErr := writeFile(tmpPath, writeFunc, contentType)
-	if creatErr != nil {
-		return "", fmt.Errorf("SaveNewConfig: error creating tmp file: [%s]: %v", tmpPath, creatErr)
+	if closeErr := w.close(); closeErr != nil {
+		fileRemove(tmpPath)
+		return "", fmt.Errorf("SaveNewConfig: error creating tmp file: [%s]: %v", tmpPath, closeErr)
 	}
 
 	defer fileRemove(tmpPath)
@@ -372,7 +457,8 @@ func SaveNewConfig(configPathPrefix string, maxFiles int, logger hasPrintf, writ
 				if removeErr := fileRemove(tmpPath); removeErr != nil {
 					logger.Printf("SaveNewConfig: error removing temp file=[%s]: %v", tmpPath, removeErr)
 				}
-				return lastConfig, nil // success
+				dropQuarantine(configPathPrefix, logger) // superseded by good config
+				return lastConfig, nil                   // success
 			}
 			// unequal
 			logger.Printf("SaveNewConfig: files differ previous=[%s] new=[%s]", lastConfig, tmpPath)
@@ -382,29 +468,63 @@ func SaveNewConfig(configPathPrefix string, maxFiles int, logger hasPrintf, writ
 		}
 	}
 
+	// shrink guard
+
+	if guard != nil && previousFound {
+		if shrinkErr := w.checkShrink(lastConfig, guard, logger); shrinkErr != nil {
+			return "", shrinkErr
+		}
+	}
+
+	path, rotateErr := rotateIn(configPathPrefix, tmpPath, id, maxFiles, logger, contentType)
+	if rotateErr == nil {
+		dropQuarantine(configPathPrefix, logger) // superseded by good config
+	}
+	return path, rotateErr
+}
+
+// rotateIn renames srcPath to the config file following commit id, updates the shortcut file and erases old files.
+func rotateIn(configPathPrefix, srcPath string, id, maxFiles int, logger hasPrintf, contentType string) (string, error) {
+
 	// get new file
 
 	newCommitID := id + 1
 	newFilepath := getConfigPath(configPathPrefix, strconv.Itoa(newCommitID))
 
-	logger.Printf("SaveNewConfig: newPath=[%s]", newFilepath)
+	logger.Printf("rotateIn: newPath=[%s]", newFilepath)
 
 	if fileExists(newFilepath) {
-		return "", fmt.Errorf("SaveNewConfig: new file exists: [%s]", newFilepath)
+		// stale shortcut: a crash after a rename kept it pointing to an older config
+		logger.Printf("rotateIn: new file exists: [%s]: rescanning", newFilepath)
+		_, maxID, scanErr := findLastByScan(configPathPrefix, logger)
+		if scanErr != nil {
+			return "", fmt.Errorf("rotateIn: new file exists: [%s]: %v", newFilepath, scanErr)
+		}
+		newCommitID = maxID + 1
+		newFilepath = getConfigPath(configPathPrefix, strconv.Itoa(newCommitID))
+		if fileExists(newFilepath) {
+			return "", fmt.Errorf("rotateIn: new file exists: [%s]", newFilepath)
+		}
 	}
 
-	// rename tmp to new file
+	// rename source to new file
 
-	if renameErr := fileRename(tmpPath, newFilepath); renameErr != nil {
-		return "", fmt.Errorf("SaveNewConfig: could not rename '%s' to '%s'; %v", tmpPath, newFilepath, renameErr)
+	if renameErr := fileRename(srcPath, newFilepath); renameErr != nil {
+		return "", fmt.Errorf("rotateIn: could not rename '%s' to '%s'; %v", srcPath, newFilepath, renameErr)
+	}
+
+	if !s3path(newFilepath) {
+		if syncErr := syncDir(filepath.Dir(newFilepath)); syncErr != nil {
+			logger.Printf("rotateIn: error syncing dir for '%s': %v", newFilepath, syncErr)
+		}
 	}
 
 	// write shortcut file
 
 	// write last id into shortcut file
 	lastIDPath := getLastIDPath(configPathPrefix)
-	if err := writeFileBuf(lastIDPath, []byte(strconv.Itoa(newCommitID)), contentType); err != nil {
-		logger.Printf("SaveNewConfig: error writing last id file '%s': %v", lastIDPath, err)
+	if err := writeShortcut(configPathPrefix, newCommitID, contentType); err != nil {
+		logger.Printf("rotateIn: error writing last id file '%s': %v", lastIDPath, err)
 
 		// since we failed to update the shortcut file,
 		// it might be pointing to old backup.
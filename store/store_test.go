@@ -1,10 +1,14 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/udhos/jazigo/temp"
 )
@@ -88,7 +92,7 @@ func storeWrite(t *testing.T, prefix, content, expected string, maxFiles int, lo
 		return nil
 	}
 
-	path, writeErr := SaveNewConfig(prefix, maxFiles, logger, writeFunc, false, contentType)
+	path, writeErr := SaveNewConfig(prefix, maxFiles, logger, writeFunc, false, contentType, nil)
 	if writeErr != nil {
 		return fmt.Errorf("storeWrite: error: %v", writeErr)
 	}
@@ -108,3 +112,250 @@ func storeWrite(t *testing.T, prefix, content, expected string, maxFiles int, lo
 
 	return nil
 }
+
+func TestConfigWriter(t *testing.T) {
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	logger := &testLogger{t}
+	prefix := filepath.Join(repo, "writer-test.")
+
+	// discarded writer leaves nothing behind
+
+	w, newErr := NewConfigWriter(prefix, "")
+	if newErr != nil {
+		t.Fatalf("NewConfigWriter: %v", newErr)
+	}
+	w2, newErr2 := NewConfigWriter(prefix, "")
+	if newErr2 != nil {
+		t.Fatalf("NewConfigWriter: second writer for same prefix: %v", newErr2)
+	}
+	if w2.tmpPath == w.tmpPath {
+		t.Errorf("NewConfigWriter: tmp file name reused: %s", w.tmpPath)
+	}
+	w.Write([]byte("partial"))
+	for _, x := range []*ConfigWriter{w, w2} {
+		if err := x.Discard(); err != nil {
+			t.Errorf("Discard: %v", err)
+		}
+	}
+	if list, _ := ListTmp(prefix); len(list) > 0 {
+		t.Errorf("Discard: tmp file left behind: %v", list)
+	}
+
+	// committed writer becomes the next config
+
+	w, newErr = NewConfigWriter(prefix, "")
+	if newErr != nil {
+		t.Fatalf("NewConfigWriter: %v", newErr)
+	}
+	for _, s := range []string{"line1\n", "line2\n"} {
+		w.Write([]byte(s))
+	}
+	if w.Size() != 12 {
+		t.Errorf("Size: got=%d want=12", w.Size())
+	}
+	path, commitErr := w.Commit(2, logger, false, nil)
+	if commitErr != nil {
+		t.Fatalf("Commit: %v", commitErr)
+	}
+	if path != prefix+"0" {
+		t.Errorf("Commit: path=%s", path)
+	}
+	b, readErr := FileRead(path, 100)
+	if readErr != nil || string(b) != "line1\nline2\n" {
+		t.Errorf("Commit: content=%q err=%v", b, readErr)
+	}
+	if list, _ := ListTmp(prefix); len(list) > 0 {
+		t.Errorf("Commit: tmp file left behind: %v", list)
+	}
+}
+
+func TestShrinkGuard(t *testing.T) {
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	logger := &testLogger{t}
+	prefix := filepath.Join(repo, "shrink-test.")
+	guard := &ShrinkGuard{Percent: 50}
+
+	save := func(content string) (string, error) {
+		writeFunc := func(w HasWrite) error {
+			_, err := w.Write([]byte(content))
+			return err
+		}
+		return SaveNewConfig(prefix, 10, logger, writeFunc, false, "", guard)
+	}
+
+	full := strings.Repeat("interface x\n", 10)
+
+	if _, err := save(full); err != nil {
+		t.Fatalf("first save: %v", err)
+	}
+	if _, err := save(full[:len(full)/2+1]); err != nil {
+		t.Errorf("small shrink: %v", err)
+	}
+
+	_, shrinkErr := save("end\n")
+	var e *ShrinkError
+	if !errors.As(shrinkErr, &e) {
+		t.Fatalf("big shrink: expected ShrinkError, got: %v", shrinkErr)
+	}
+	if e.Path != QuarantinePath(prefix) || e.NewLines != 1 {
+		t.Errorf("big shrink: %+v", e)
+	}
+	if last, _ := FindLastConfig(prefix, logger); last != prefix+"1" {
+		t.Errorf("big shrink: last config changed: %s", last)
+	}
+
+	path, acceptErr := AcceptQuarantine(prefix, 10, logger)
+	if acceptErr != nil {
+		t.Fatalf("accept: %v", acceptErr)
+	}
+	if last, _ := FindLastConfig(prefix, logger); path != prefix+"2" || last != path {
+		t.Errorf("accept: path=%s last=%s", path, last)
+	}
+	if FileExists(QuarantinePath(prefix)) {
+		t.Errorf("accept: quarantine left behind")
+	}
+	if _, err := AcceptQuarantine(prefix, 10, logger); err == nil {
+		t.Errorf("accept: expected error without quarantined config")
+	}
+
+	// good config supersedes quarantine
+
+	if _, err := save(full); err != nil {
+		t.Fatalf("regrow: %v", err)
+	}
+	if _, err := save("end\n"); !errors.As(err, &e) {
+		t.Fatalf("second big shrink: expected ShrinkError, got: %v", err)
+	}
+	if _, err := save(full); err != nil {
+		t.Fatalf("good after shrink: %v", err)
+	}
+	if FileExists(QuarantinePath(prefix)) {
+		t.Errorf("good after shrink: quarantine left behind")
+	}
+
+	// quarantine older than last config is refused
+
+	if _, err := save("end\n"); !errors.As(err, &e) {
+		t.Fatalf("third big shrink: expected ShrinkError, got: %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(QuarantinePath(prefix), old, old)
+	last, _ := FindLastConfig(prefix, logger)
+	if _, err := AcceptQuarantine(prefix, 10, logger); err == nil {
+		t.Errorf("accept: expected error for quarantine older than last config")
+	}
+	if got, _ := FindLastConfig(prefix, logger); got != last {
+		t.Errorf("accept stale quarantine: last config changed: %s", got)
+	}
+}
+
+func TestRecover(t *testing.T) {
+
+	repo := temp.MakeTempRepo()
+	defer temp.CleanupTempRepo()
+
+	logger := &testLogger{t}
+	prefix := filepath.Join(repo, "recover-test.")
+
+	save := func(content string) string {
+		writeFunc := func(w HasWrite) error {
+			_, err := w.Write([]byte(content))
+			return err
+		}
+		path, err := SaveNewConfig(prefix, 10, logger, writeFunc, false, "", nil)
+		if err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		return path
+	}
+
+	save("a")
+	save("b")
+
+	// crash leftovers: legacy and unique tmp files, one of them still fresh
+
+	stale := []string{prefix + "tmp", prefix + "last.tmp", prefix + "tmp-0123456789abcdef.tmp"}
+	fresh := prefix + "tmp-fedcba9876543210.tmp"
+	old := time.Now().Add(-2 * StaleTmpAge)
+	for _, p := range append(stale, fresh) {
+		if err := FileWrite(p, []byte("partial")); err != nil {
+			t.Fatalf("write tmp: %v", err)
+		}
+	}
+	for _, p := range stale {
+		os.Chtimes(p, old, old)
+	}
+
+	if got := save("c"); got != prefix+"2" {
+		t.Errorf("save with stale tmp files: path=%s", got)
+	}
+	for _, p := range stale {
+		if FileExists(p) {
+			t.Errorf("stale tmp file not removed: %s", p)
+		}
+	}
+	if !FileExists(fresh) {
+		t.Errorf("fresh tmp file removed: %s", fresh)
+	}
+
+	// crash between rename and shortcut update: shortcut points to older config
+
+	if err := FileWrite(getLastIDPath(prefix), []byte("1")); err != nil {
+		t.Fatalf("write shortcut: %v", err)
+	}
+	if got := save("d"); got != prefix+"3" {
+		t.Errorf("save with stale shortcut: path=%s", got)
+	}
+
+	// inconsistent and missing shortcut are rebuilt
+
+	FileWrite(getLastIDPath(prefix), []byte("0"))
+	if fixed, err := RepairShortcut(prefix, logger); !fixed || err != nil {
+		t.Errorf("RepairShortcut: inconsistent: fixed=%v err=%v", fixed, err)
+	}
+	if last, _ := FindLastConfig(prefix, logger); last != prefix+"3" {
+		t.Errorf("RepairShortcut: last=%s", last)
+	}
+	if fixed, _ := RepairShortcut(prefix, logger); fixed {
+		t.Errorf("RepairShortcut: rewrote consistent shortcut")
+	}
+
+	os.Remove(getLastIDPath(prefix))
+	if last, _ := FindLastConfig(prefix, logger); last != prefix+"3" {
+		t.Errorf("FindLastConfig: missing shortcut: last=%s", last)
+	}
+	if !FileExists(getLastIDPath(prefix)) {
+		t.Errorf("FindLastConfig: missing shortcut not rebuilt")
+	}
+
+	// concurrent shortcut writers: UI rebuilding the shortcut while a commit rotates in
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := writeShortcut(prefix, 3, ""); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent writeShortcut: %v", err)
+	}
+	if id, _ := fileFirstLine(getLastIDPath(prefix)); id != "3" {
+		t.Errorf("concurrent writeShortcut: shortcut=%q", id)
+	}
+	if list, _ := ListTmp(prefix); len(list) != 1 || list[0] != fresh {
+		t.Errorf("concurrent writeShortcut: tmp files left behind: %v", list)
+	}
+}